@@ -1,6 +1,8 @@
 package wok
 
 import (
+	"time"
+
 	"github.com/manvalls/wit"
 )
 
@@ -34,27 +36,82 @@ func Handle(fn func(r Request) wit.Command) Options {
 	return DefaultOptions.Handle(fn)
 }
 
+// Provide makes the given values available through Request.Service, indexed
+// by their dynamic type, to the plans of this controller and its children
+func Provide(values ...interface{}) Options {
+	return DefaultOptions.Provide(values...)
+}
+
+// ProvideAs makes the given value available through Request.Service, indexed
+// by the type pointed to by ptr, to the plans of this controller and its children
+func ProvideAs(ptr interface{}, value interface{}) Options {
+	return DefaultOptions.ProvideAs(ptr, value)
+}
+
 // Sync runs plans sequentially
 func Sync() Options {
 	return DefaultOptions.Sync()
 }
 
+// Stream allows the response to be sent before plans finish, delivering
+// their commands later on through the socket, or as patches when the
+// response format supports them
+func Stream() Options {
+	return DefaultOptions.Stream()
+}
+
+// Timeout cancels the context of plans running for longer than the given
+// duration, applying the provided fallback commands instead
+func Timeout(d time.Duration, fallback ...wit.Command) Options {
+	return DefaultOptions.Timeout(d, fallback...)
+}
+
+// Suspense applies the provided placeholder commands when non-sync plans
+// take longer than the given duration, delivering their commands later on
+// through the socket, or as patches of HTML responses and other formats
+// supporting them. Plans aren't suspended within responses whose format
+// doesn't support patches, such as JSON ones, which wait for them instead.
+func Suspense(d time.Duration, placeholder ...wit.Command) Options {
+	return DefaultOptions.Suspense(d, placeholder...)
+}
+
 // Excl runs plans exclusively, no other plan is allowed
 // to run at the same time
 func Excl() Options {
 	return DefaultOptions.Excl()
 }
 
+// As names the derived plans, allowing other plans at the same level or
+// below to use the results they publish with Request.SetResult
+func As(name string) Options {
+	return DefaultOptions.As(name)
+}
+
+// Needs runs the derived plans once the nearest plans with the given names,
+// at the same level or above, finish, making their results available
+// through Request.Result. Needed plans run even when their commands don't
+// need to be applied again, which are then discarded.
+func Needs(names ...string) Options {
+	return DefaultOptions.Needs(names...)
+}
+
 // Always runs plans even if it wouldn't be necessary
 func Always() Options {
 	return DefaultOptions.Always()
 }
 
-// With makes the given list of parameters available to the derived plans
+// With makes the given list of parameters available to the derived plans,
+// which will only be run again when one of them changes
 func With(params ...string) Options {
 	return DefaultOptions.With(params...)
 }
 
+// WithAll makes every parameter available to the derived plans,
+// which will be run again when any of them changes
+func WithAll() Options {
+	return DefaultOptions.WithAll()
+}
+
 // Navigation runs plans on navigation
 func Navigation() Options {
 	return DefaultOptions.Navigation()
@@ -125,6 +182,12 @@ func NoDelete() Options {
 	return DefaultOptions.NoDelete()
 }
 
+// NoHead is an alias for NoMethod("HEAD"), skipping plans which
+// only contribute to the body of the response
+func NoHead() Options {
+	return DefaultOptions.NoHead()
+}
+
 // Socket runs plans on socket request
 func Socket() Options {
 	return DefaultOptions.Socket()
@@ -144,3 +207,62 @@ func Call(calls ...string) Options {
 func NoCall(calls ...string) Options {
 	return DefaultOptions.NoCall(calls...)
 }
+
+// Bind parses the parameters of the derived plans into a new instance of the
+// provided struct, available as Request.Bound, making them available as With
+// does. Plans aren't run when parameters fail to bind, setting a 400 status code.
+func Bind(prototype interface{}) Options {
+	return DefaultOptions.Bind(prototype)
+}
+
+// OnInvalidParams applies the command returned by the provided function
+// instead of the derived plans when their parameters fail to bind
+func OnInvalidParams(fn func(r Request, errs ParamErrors) wit.Command) Options {
+	return DefaultOptions.OnInvalidParams(fn)
+}
+
+// CorrectParams issues an internal redirection to corrected parameters when
+// the ones of the derived plans fail to bind, replacing invalid values with
+// their defaults or limits
+func CorrectParams() Options {
+	return DefaultOptions.CorrectParams()
+}
+
+// Guard protects the plans of this controller and its children, which won't
+// run unless the provided guard allows the request. Returning nil allows it,
+// while returning an error denies it, using the status code of an *Error or
+// 403 otherwise. Guards run before any of these plans, regardless of filters,
+// and may redirect the request through Redirect or URLRedirect. Responses of
+// guarded routes aren't stored by the response cache unless the guard is
+// derived from cacheable options, see Options.Cacheable.
+func Guard(fn func(r Request) error) Options {
+	return DefaultOptions.Guard(fn)
+}
+
+// RateLimit limits the number of times plans run, sharing the given limiter
+// among every request matching the same dimensions, ByInstance by default.
+// Requests are limited once, however many plans share the limiter. Limited
+// plans don't run, and the response gets a 429 status code and a
+// Retry-After header.
+func RateLimit(limiter Limiter, dimensions ...RateDimension) Options {
+	return DefaultOptions.RateLimit(limiter, dimensions...)
+}
+
+// Cacheable allows the commands of plans to be stored by the response cache
+// of the handler for the given time. Responses are only stored when every
+// plan producing them is cacheable, using the shortest time and every tag.
+// Guards derived from cacheable options let the responses they protect be
+// stored, declaring their decision only depends on the headers these vary on.
+func Cacheable(ttl time.Duration, tags ...string) Options {
+	return DefaultOptions.Cacheable(ttl, tags...)
+}
+
+// Cache memoizes the commands returned by plans across requests, indexed by
+// the key returned by the provided function, which should identify both the
+// plan and the data it depends on. Socket requests always run the plans.
+// Only commands are stored: when found, the plans don't run, so they shouldn't
+// have any other effect, such as setting headers, validators, status codes or
+// results, loading dependencies or redirecting. Cached plans can't be named.
+func Cache(key func(r Request) string, ttl time.Duration, tags ...string) Options {
+	return DefaultOptions.Cache(key, ttl, tags...)
+}
@@ -0,0 +1,308 @@
+package wok
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ParamErrors maps parameter names to the reason they failed to bind
+type ParamErrors map[string]string
+
+func (e ParamErrors) Error() string {
+	names := make([]string, 0, len(e))
+	for name := range e {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	messages := make([]string, len(names))
+	for i, name := range names {
+		messages[i] = name + ": " + e[name]
+	}
+
+	return strings.Join(messages, ", ")
+}
+
+type paramField struct {
+	index      int
+	name       string
+	required   bool
+	hasDefault bool
+	defaults   []string
+	min        *float64
+	max        *float64
+	enum       map[string]bool
+}
+
+type binder struct {
+	typ    reflect.Type
+	fields []paramField
+}
+
+// newBinder builds a binder for the type of the provided struct, whose fields
+// are described by tags of the form:
+//
+//	`param:"name,required,default=1,min=1,max=10,enum=1|2|5|10"`
+//
+// Fields without a param tag are ignored. Strings, booleans, integers, floats
+// and slices of them are supported.
+func newBinder(prototype interface{}) *binder {
+	typ := reflect.TypeOf(prototype)
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	if typ.Kind() != reflect.Struct {
+		panic("wok: Bind requires a struct")
+	}
+
+	b := &binder{typ: typ}
+
+	for i := 0; i < typ.NumField(); i++ {
+		tag, ok := typ.Field(i).Tag.Lookup("param")
+		if !ok || tag == "-" {
+			continue
+		}
+
+		if typ.Field(i).PkgPath != "" {
+			panic("wok: unexported param field " + typ.Field(i).Name)
+		}
+
+		fieldType := typ.Field(i).Type
+		if fieldType.Kind() == reflect.Slice {
+			fieldType = fieldType.Elem()
+		}
+
+		if !supportedParam(fieldType) {
+			panic("wok: unsupported param type " + fieldType.String())
+		}
+
+		parts := strings.Split(tag, ",")
+		field := paramField{
+			index: i,
+			name:  parts[0],
+		}
+
+		if field.name == "" {
+			field.name = typ.Field(i).Name
+		}
+
+		for _, part := range parts[1:] {
+			keyValue := strings.SplitN(part, "=", 2)
+			value := ""
+			if len(keyValue) == 2 {
+				value = keyValue[1]
+			}
+
+			switch keyValue[0] {
+			case "required":
+				field.required = true
+			case "default":
+				field.hasDefault = true
+				field.defaults = strings.Split(value, "|")
+			case "min":
+				field.min = parseLimit(value)
+			case "max":
+				field.max = parseLimit(value)
+			case "enum":
+				field.enum = map[string]bool{}
+				for _, option := range strings.Split(value, "|") {
+					field.enum[option] = true
+				}
+			}
+		}
+
+		b.fields = append(b.fields, field)
+	}
+
+	return b
+}
+
+func supportedParam(typ reflect.Type) bool {
+	switch typ.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+
+	return false
+}
+
+func parseLimit(value string) *float64 {
+	limit, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		panic("wok: invalid param limit " + strconv.Quote(value))
+	}
+
+	return &limit
+}
+
+func (b *binder) names() []string {
+	names := make([]string, len(b.fields))
+	for i, field := range b.fields {
+		names[i] = field.name
+	}
+
+	return names
+}
+
+// bind parses the provided parameters into a new struct, returning
+// the corrected values of the failing parameters when they can be fixed
+func (b *binder) bind(params Params) (interface{}, Params, ParamErrors) {
+	ptr := reflect.New(b.typ)
+	target := ptr.Elem()
+
+	var errs ParamErrors
+	var corrected Params
+	correctable := true
+
+	fail := func(field paramField, message string, correction []string, ok bool) {
+		if errs == nil {
+			errs = ParamErrors{}
+			corrected = Params{}
+		}
+
+		errs[field.name] = message
+		corrected[field.name] = correction
+		correctable = correctable && ok
+	}
+
+	for _, field := range b.fields {
+		values := params[field.name]
+		if len(values) == 0 {
+			if field.hasDefault {
+				values = field.defaults
+			} else if field.required {
+				fail(field, "required", nil, false)
+				continue
+			} else {
+				continue
+			}
+		}
+
+		value := target.Field(field.index)
+		kind := value.Kind()
+		if kind == reflect.Slice {
+			value.Set(reflect.MakeSlice(value.Type(), 0, len(values)))
+		} else {
+			values = values[:1]
+		}
+
+		valid := []string{}
+		message := ""
+
+		for _, raw := range values {
+			parsed, fixed, msg := field.parse(value, raw)
+			if msg != "" {
+				message = msg
+				if fixed != "" {
+					valid = append(valid, fixed)
+				}
+
+				continue
+			}
+
+			if kind == reflect.Slice {
+				value.Set(reflect.Append(value, parsed))
+			} else {
+				value.Set(parsed)
+			}
+
+			valid = append(valid, raw)
+		}
+
+		if message != "" {
+			if len(valid) == 0 && field.hasDefault {
+				valid = field.defaults
+			}
+
+			fail(field, message, valid, len(valid) > 0 || !field.required)
+		}
+	}
+
+	if errs != nil {
+		if !correctable {
+			corrected = nil
+		}
+
+		return nil, corrected, errs
+	}
+
+	return ptr.Interface(), nil, nil
+}
+
+// parse converts a raw value to the type of the field, returning an error
+// message and a corrected raw value, if any, when it fails
+func (field paramField) parse(value reflect.Value, raw string) (reflect.Value, string, string) {
+	typ := value.Type()
+	if typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+
+	if field.enum != nil && !field.enum[raw] {
+		fixed := ""
+		if field.hasDefault && len(field.defaults) > 0 {
+			fixed = field.defaults[0]
+		}
+
+		return reflect.Value{}, fixed, "invalid option"
+	}
+
+	result := reflect.New(typ).Elem()
+	var number float64
+
+	switch typ.Kind() {
+	case reflect.String:
+		result.SetString(raw)
+		return result, "", ""
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return reflect.Value{}, "", "invalid boolean"
+		}
+
+		result.SetBool(b)
+		return result, "", ""
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(raw, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, "", "invalid integer"
+		}
+
+		result.SetInt(i)
+		number = float64(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(raw, 10, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, "", "invalid integer"
+		}
+
+		result.SetUint(u)
+		number = float64(u)
+	case reflect.Float32, reflect.Float64:
+		f, err := strconv.ParseFloat(raw, typ.Bits())
+		if err != nil {
+			return reflect.Value{}, "", "invalid number"
+		}
+
+		result.SetFloat(f)
+		number = f
+	default:
+		panic("wok: unsupported param type " + typ.String())
+	}
+
+	if field.min != nil && number < *field.min {
+		return reflect.Value{}, strconv.FormatFloat(*field.min, 'f', -1, 64), "too small"
+	}
+
+	if field.max != nil && number > *field.max {
+		return reflect.Value{}, strconv.FormatFloat(*field.max, 'f', -1, 64), "too big"
+	}
+
+	return result, "", ""
+}
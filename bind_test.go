@@ -0,0 +1,123 @@
+package wok
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manvalls/way"
+	"github.com/manvalls/wit"
+)
+
+type listParams struct {
+	Page  int      `param:"page,default=1,min=1,max=10"`
+	Sort  string   `param:"sort,enum=asc|desc"`
+	Tags  []string `param:"tag"`
+	Query string   `param:"q,required"`
+	Other string
+}
+
+func TestBinderBindsParams(t *testing.T) {
+	bound, _, errs := newBinder(listParams{}).bind(Params{
+		"page": {"3"},
+		"sort": {"desc"},
+		"tag":  {"a", "b"},
+		"q":    {"x"},
+	})
+
+	if errs != nil {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	params := bound.(*listParams)
+	if params.Page != 3 || params.Sort != "desc" || len(params.Tags) != 2 || params.Query != "x" {
+		t.Errorf("unexpected params %+v", params)
+	}
+}
+
+func TestBinderDefaults(t *testing.T) {
+	bound, _, errs := newBinder(listParams{}).bind(Params{"q": {"x"}})
+	if errs != nil {
+		t.Fatalf("unexpected errors %v", errs)
+	}
+
+	if params := bound.(*listParams); params.Page != 1 {
+		t.Errorf("expected the default page, got %d", params.Page)
+	}
+}
+
+func TestBinderErrorsAndCorrections(t *testing.T) {
+	_, corrected, errs := newBinder(listParams{}).bind(Params{
+		"page": {"20"},
+		"sort": {"up"},
+		"q":    {"x"},
+	})
+
+	if errs["page"] != "too big" || errs["sort"] != "invalid option" {
+		t.Errorf("unexpected errors %v", errs)
+	}
+
+	if corrected == nil || corrected["page"][0] != "10" {
+		t.Errorf("unexpected corrections %v", corrected)
+	}
+
+	_, corrected, errs = newBinder(listParams{}).bind(Params{})
+	if errs["q"] != "required" || corrected != nil {
+		t.Errorf("missing required params can't be corrected: %v %v", errs, corrected)
+	}
+}
+
+func expectPanic(t *testing.T, fn func()) {
+	t.Helper()
+	defer func() {
+		if recover() == nil {
+			t.Error("expected a panic")
+		}
+	}()
+
+	fn()
+}
+
+func TestBindRejectsInvalidStructs(t *testing.T) {
+	expectPanic(t, func() {
+		Bind(struct {
+			At time.Time `param:"at"`
+		}{})
+	})
+
+	expectPanic(t, func() {
+		Bind(struct {
+			page int `param:"page"`
+		}{})
+	})
+
+	expectPanic(t, func() {
+		Bind(42)
+	})
+}
+
+func TestBindWithinRequests(t *testing.T) {
+	h := Handler{
+		Root: func() Controller {
+			return NewTree(Bind(listParams{}).OnInvalidParams(func(r Request, errs ParamErrors) wit.Command {
+				return text("INVALID " + errs.Error())
+			}).Run(func(r Request) wit.Command {
+				return text(fmt.Sprint("PAGE ", r.Bound.(*listParams).Page))
+			}), nil)
+		},
+		Router: way.BuildRouter(way.RouteMap{"/": {}}),
+	}
+
+	w := serve(h, "GET", "/?q=x&page=4", "Accept", "application/json")
+	expectStatus(t, w, 200)
+	if !strings.Contains(w.Body.String(), "PAGE 4") {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+
+	w = serve(h, "GET", "/?page=4", "Accept", "application/json")
+	expectStatus(t, w, 400)
+	if !strings.Contains(w.Body.String(), "INVALID q: required") {
+		t.Errorf("unexpected response %s", w.Body.String())
+	}
+}
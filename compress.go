@@ -0,0 +1,151 @@
+package wok
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+
+	"github.com/golang/gddo/httputil"
+)
+
+const defaultCompressMinSize = 1024
+
+// gzipWriter compresses responses whose body reaches a minimum size,
+// buffering them until then, a negative size compressing every response
+type gzipWriter struct {
+	http.ResponseWriter
+	minSize    int
+	statusCode int
+	buffer     bytes.Buffer
+	decided    bool
+	gz         *gzip.Writer
+
+	// omitted is the size of the body left out of HEAD responses,
+	// which are compressed as if it was there
+	omitted int
+}
+
+func newGzipWriter(w http.ResponseWriter, minSize int) *gzipWriter {
+	if minSize == 0 {
+		minSize = defaultCompressMinSize
+	}
+
+	return &gzipWriter{
+		ResponseWriter: w,
+		minSize:        minSize,
+	}
+}
+
+// compressible checks whether the response may be compressed
+func (w *gzipWriter) compressible() bool {
+	switch w.statusCode {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+
+	if w.statusCode < 200 {
+		return false
+	}
+
+	return w.Header().Get("Content-Encoding") == ""
+}
+
+// decide sends the headers, compressing the rest of the response if possible
+func (w *gzipWriter) decide(compress bool) {
+	if w.decided {
+		return
+	}
+
+	w.decided = true
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	if compress && w.compressible() {
+		header := w.Header()
+		header.Set("Content-Encoding", "gzip")
+		header.Del("Content-Length")
+
+		if w.omitted == 0 {
+			w.gz = gzip.NewWriter(w.ResponseWriter)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	if w.buffer.Len() > 0 {
+		w.write(w.buffer.Bytes())
+		w.buffer.Reset()
+	}
+}
+
+func (w *gzipWriter) write(p []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(p)
+	}
+
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *gzipWriter) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+	}
+}
+
+func (w *gzipWriter) Write(p []byte) (int, error) {
+	if w.decided {
+		return w.write(p)
+	}
+
+	if w.statusCode == 0 {
+		w.statusCode = http.StatusOK
+	}
+
+	w.buffer.Write(p)
+	if w.buffer.Len() >= w.minSize {
+		w.decide(true)
+	}
+
+	return len(p), nil
+}
+
+// Flush sends what's been written so far, compressing it
+// only if it reached the minimum size
+func (w *gzipWriter) Flush() {
+	w.decide(w.buffer.Len() >= w.minSize)
+
+	if w.gz != nil {
+		w.gz.Flush()
+	}
+
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// omit records the size of the body left out of a HEAD response
+func (w *gzipWriter) omit(size int) {
+	w.omitted += size
+}
+
+// close finishes the response
+func (w *gzipWriter) close() {
+	w.decide(w.buffer.Len()+w.omitted >= w.minSize)
+
+	if w.gz != nil {
+		w.gz.Close()
+	}
+}
+
+// byteCounter counts the bytes written to it
+type byteCounter int
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// acceptsGzip checks whether the client accepts gzip-encoded responses
+func acceptsGzip(r *http.Request) bool {
+	return httputil.NegotiateContentEncoding(r, []string{"gzip"}) == "gzip"
+}
@@ -0,0 +1,34 @@
+package wok
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/manvalls/wit"
+)
+
+func TestCompressHeadLikeGet(t *testing.T) {
+	for _, size := range []int{10, 4096} {
+		h := single(func() Controller {
+			return NewTree(Run(func(r Request) wit.Command {
+				return text(strings.Repeat("a", size))
+			}), nil)
+		})
+
+		h.Compress = true
+
+		get := serve(h, "GET", "/", "Accept-Encoding", "gzip")
+		head := serve(h, "HEAD", "/", "Accept-Encoding", "gzip")
+		expectStatus(t, get, 200)
+		expectStatus(t, head, 200)
+
+		if get.Header().Get("Content-Encoding") != head.Header().Get("Content-Encoding") {
+			t.Errorf("size %d: GET encoded as %q, HEAD as %q", size,
+				get.Header().Get("Content-Encoding"), head.Header().Get("Content-Encoding"))
+		}
+
+		if head.Body.Len() != 0 {
+			t.Errorf("size %d: HEAD response has a body", size)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package wok
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// validators gathers the cache validators contributed by the plans of a request
+type validators struct {
+	mutex    sync.Mutex
+	etags    map[*planInfo][]string
+	modified map[*planInfo]time.Time
+	plans    []*planInfo
+	guards   []*planInfo
+}
+
+func newValidators() *validators {
+	return &validators{
+		etags:    make(map[*planInfo][]string),
+		modified: make(map[*planInfo]time.Time),
+	}
+}
+
+// ETag contributes a fragment to the entity tag of the response, which is
+// only emitted when every plan returning commands contributes a validator
+func (r Request) ETag(fragment string) {
+	if r.validators == nil {
+		return
+	}
+
+	r.validators.mutex.Lock()
+	defer r.validators.mutex.Unlock()
+
+	r.validators.etags[r.current] = append(r.validators.etags[r.current], fragment)
+}
+
+// LastModified contributes the modification time of the data used by the
+// current plan, the latest time of every plan being used for the response
+func (r Request) LastModified(t time.Time) {
+	if r.validators == nil {
+		return
+	}
+
+	r.validators.mutex.Lock()
+	defer r.validators.mutex.Unlock()
+
+	if t.After(r.validators.modified[r.current]) {
+		r.validators.modified[r.current] = t
+	}
+}
+
+// setPlans records the plans whose commands make up the response,
+// along with the guards protecting them
+func (v *validators) setPlans(plansInfo []*planInfo, guards []*planInfo) {
+	if v == nil {
+		return
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	v.plans = append(v.plans[:0], plansInfo...)
+	v.guards = append(v.guards[:0], guards...)
+}
+
+// compute combines the contributed validators along with the provided
+// values, failing if some plan didn't contribute any of them or was
+// detached from the response
+func (v *validators) compute(values ...string) (etag string, lastModified time.Time, ok bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	fragments := []string{}
+	allModified := true
+	contributed := false
+
+	for _, info := range v.plans {
+		if info.fn == nil {
+			continue
+		}
+
+		if info.detached {
+			return "", time.Time{}, false
+		}
+
+		contributed = true
+		etags, hasETag := v.etags[info]
+		modified, hasModified := v.modified[info]
+		if !hasETag && !hasModified {
+			return "", time.Time{}, false
+		}
+
+		fragments = append(fragments, etags...)
+		if hasModified {
+			fragments = append(fragments, strconv.FormatInt(modified.UnixNano(), 10))
+			if modified.After(lastModified) {
+				lastModified = modified
+			}
+		} else {
+			allModified = false
+		}
+	}
+
+	if !contributed {
+		return "", time.Time{}, false
+	}
+
+	if !allModified {
+		lastModified = time.Time{}
+	}
+
+	sort.Strings(fragments)
+
+	hash := sha1.New()
+	for _, fragment := range append(fragments, values...) {
+		hash.Write([]byte(fragment))
+		hash.Write([]byte{0})
+	}
+
+	return `W/"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`, lastModified, true
+}
+
+// notModified checks the conditional headers of the request
+// against the provided validators
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if header := r.Header.Get("If-None-Match"); header != "" {
+		for _, candidate := range strings.Split(header, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	if lastModified.IsZero() {
+		return false
+	}
+
+	since, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+
+	return !lastModified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,79 @@
+package wok
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/manvalls/wit"
+)
+
+func TestConditionalETag(t *testing.T) {
+	version := "v1"
+	h := single(func() Controller {
+		return NewTree(Run(func(r Request) wit.Command {
+			r.ETag(version)
+			return text("CONTENT")
+		}), nil)
+	})
+
+	w := serve(h, "GET", "/", "X-Wok-Instance-ID", "client")
+	expectStatus(t, w, 200)
+
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatal("missing ETag header")
+	}
+
+	w = serve(h, "GET", "/", "X-Wok-Instance-ID", "client", "If-None-Match", etag)
+	expectStatus(t, w, 304)
+	if w.Body.Len() != 0 {
+		t.Errorf("unexpected body %s", w.Body.String())
+	}
+
+	version = "v2"
+	w = serve(h, "GET", "/", "X-Wok-Instance-ID", "client", "If-None-Match", etag)
+	expectStatus(t, w, 200)
+	if w.Header().Get("ETag") == etag {
+		t.Error("the ETag didn't change along with the data")
+	}
+}
+
+func TestConditionalLastModified(t *testing.T) {
+	modified := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := single(func() Controller {
+		return NewTree(Run(func(r Request) wit.Command {
+			r.LastModified(modified)
+			return text("CONTENT")
+		}), nil)
+	})
+
+	w := serve(h, "GET", "/", "X-Wok-Instance-ID", "client")
+	expectStatus(t, w, 200)
+	if w.Header().Get("Last-Modified") != modified.Format(http.TimeFormat) {
+		t.Fatalf("unexpected Last-Modified %q", w.Header().Get("Last-Modified"))
+	}
+
+	since := modified.Add(time.Hour).Format(http.TimeFormat)
+	expectStatus(t, serve(h, "GET", "/", "X-Wok-Instance-ID", "client", "If-Modified-Since", since), 304)
+}
+
+func TestConditionalNeedsEveryPlan(t *testing.T) {
+	h := single(func() Controller {
+		return NewTree(List(
+			Run(func(r Request) wit.Command {
+				r.ETag("v1")
+				return text("VALIDATED")
+			}),
+			Run(func(r Request) wit.Command {
+				return text("UNVALIDATED")
+			}),
+		), nil)
+	})
+
+	w := serve(h, "GET", "/", "X-Wok-Instance-ID", "client")
+	expectStatus(t, w, 200)
+	if w.Header().Get("ETag") != "" {
+		t.Errorf("unexpected ETag %q", w.Header().Get("ETag"))
+	}
+}
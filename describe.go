@@ -0,0 +1,225 @@
+package wok
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/manvalls/way"
+)
+
+// Describable controllers list their children, allowing the
+// controller tree to be walked
+type Describable interface {
+	Children() []string
+}
+
+// PlanDescription describes a plan and its options
+type PlanDescription struct {
+	Kind            string
+	Name            string
+	Needs           []string
+	Methods         []string
+	ExcludedMethods []string
+	Calls           []string
+	ExcludedCalls   []string
+	Params          []string
+	AllParams       bool
+	Navigation      bool
+	AJAX            bool
+	Socket          string
+	Always          bool
+	Sync            bool
+	Exclusive       bool
+	Stream          bool
+	Timeout         time.Duration
+	Suspense        time.Duration
+}
+
+// RouteDescription describes a route of the controller tree
+type RouteDescription struct {
+	Route  []string
+	URL    string
+	Routed bool
+	Plans  []PlanDescription
+}
+
+const maxDescribeDepth = 64
+
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for key, value := range m {
+		if value {
+			keys = append(keys, key)
+		}
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func describePlan(p plan) PlanDescription {
+	description := PlanDescription{
+		Kind:            "command",
+		Name:            p.name,
+		Needs:           p.needs,
+		Methods:         sortedKeys(p.methods),
+		ExcludedMethods: sortedKeys(p.exclMethods),
+		Calls:           sortedKeys(p.calls),
+		ExcludedCalls:   sortedKeys(p.exclCalls),
+		Params:          p.params,
+		AllParams:       p.allParams,
+		Navigation:      p.navigation,
+		AJAX:            p.ajax,
+		Always:          p.handler,
+		Sync:            p.sync,
+		Exclusive:       p.exclusive,
+		Stream:          p.stream,
+		Timeout:         p.timeout,
+		Suspense:        p.suspense,
+	}
+
+	switch {
+	case p.guard != nil:
+		description.Kind = "guard"
+	case p.fn != nil:
+		description.Kind = "run"
+	case p.doFn != nil:
+		description.Kind = "tap"
+	case p.deps != nil:
+		description.Kind = "deps"
+	case len(p.services) > 0:
+		description.Kind = "provide"
+	}
+
+	switch p.socket {
+	case trueField:
+		description.Socket = "always"
+	case falseField:
+		description.Socket = "never"
+	}
+
+	return description
+}
+
+// Describe walks the tree of describable controllers starting at the given
+// root, returning the description of every route found
+func Describe(root Controller) []RouteDescription {
+	descriptions := []RouteDescription{}
+
+	var walk func(controller Controller, route []string)
+	walk = func(controller Controller, route []string) {
+		description := RouteDescription{
+			Route: way.Clone(route),
+			Plans: []PlanDescription{},
+		}
+
+		plan := controller.Plan()
+		if plan != nil {
+			for _, p := range plan.Procedure().plans {
+				description.Plans = append(description.Plans, describePlan(p))
+			}
+		}
+
+		descriptions = append(descriptions, description)
+
+		describable, ok := controller.(Describable)
+		if !ok || len(route) >= maxDescribeDepth {
+			return
+		}
+
+		for _, child := range describable.Children() {
+			walk(controller.Resolve(child), append(way.Clone(route), child))
+		}
+	}
+
+	walk(root, []string{})
+	return descriptions
+}
+
+// Describe walks the controller tree of this handler, returning the
+// description of every route found, along with its URL when routed
+func (h Handler) Describe() []RouteDescription {
+	descriptions := Describe(h.Root())
+
+	for i, description := range descriptions {
+		url, err := h.GetURL(way.Params{}, description.Route...)
+		descriptions[i].Routed = err != way.ErrNotFound
+		if err == nil {
+			descriptions[i].URL = url
+		}
+	}
+
+	return descriptions
+}
+
+// CheckRoutes compares the controller tree of this handler with the provided
+// route maps, returning the routes of the tree without a URL and the routes
+// of the maps without a controller
+func (h Handler) CheckRoutes(maps ...way.RouteMap) (unrouted [][]string, uncontrolled [][]string) {
+	known := map[string]bool{}
+	for _, description := range h.Describe() {
+		known[strings.Join(description.Route, ",")] = true
+		if !description.Routed {
+			unrouted = append(unrouted, description.Route)
+		}
+	}
+
+	for _, m := range maps {
+		for _, route := range m {
+			if !known[strings.Join(route, ",")] {
+				uncontrolled = append(uncontrolled, way.Clone(route))
+			}
+		}
+	}
+
+	return
+}
+
+func describeFilter(included []string, excluded []string) string {
+	filter := make([]string, 0, len(included)+len(excluded))
+	filter = append(filter, included...)
+	for _, value := range excluded {
+		filter = append(filter, "!"+value)
+	}
+
+	return strings.Join(filter, ",")
+}
+
+// PrintRoutes writes a human readable table of the routes of this handler
+func (h Handler) PrintRoutes(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROUTE\tURL\tKIND\tNAME\tMETHODS\tCALLS\tNAV\tAJAX\tSOCKET\tPARAMS")
+
+	for _, description := range h.Describe() {
+		route := "/" + strings.Join(description.Route, "/")
+		url := description.URL
+		if !description.Routed {
+			url = "-"
+		}
+
+		if len(description.Plans) == 0 {
+			fmt.Fprintf(tw, "%s\t%s\t\t\t\t\t\t\t\t\n", route, url)
+		}
+
+		for _, p := range description.Plans {
+			methods := describeFilter(p.Methods, p.ExcludedMethods)
+			calls := describeFilter(p.Calls, p.ExcludedCalls)
+
+			params := strings.Join(p.Params, ",")
+			if p.AllParams {
+				params = "*"
+			}
+
+			fmt.Fprintf(
+				tw, "%s\t%s\t%s\t%s\t%s\t%s\t%t\t%t\t%s\t%s\n",
+				route, url, p.Kind, p.Name, methods, calls, p.Navigation, p.AJAX, p.Socket, params,
+			)
+		}
+	}
+
+	return tw.Flush()
+}
@@ -0,0 +1,50 @@
+package wok
+
+import (
+	"net/http"
+)
+
+// Error describes a framework-level failure, such as a URL which couldn't be
+// routed or a malformed header
+type Error struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.StatusCode)
+	}
+
+	return http.StatusText(e.StatusCode) + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// errorRoot applies the error plan along with the plan of the root controller
+type errorRoot struct {
+	Controller
+	plan Plan
+}
+
+func (c errorRoot) Plan() Plan {
+	return List(c.Controller.Plan(), always(c.plan))
+}
+
+// always forces the plans of the given plan to run on every request
+func always(p Plan) Procedure {
+	plans := []plan{}
+	if p == nil {
+		return Procedure{plans}
+	}
+
+	for _, p := range p.Procedure().plans {
+		p.handler = true
+		plans = append(plans, p)
+	}
+
+	return Procedure{plans}
+}
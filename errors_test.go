@@ -0,0 +1,19 @@
+package wok
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestErrorMessage(t *testing.T) {
+	err := &Error{StatusCode: http.StatusNotFound}
+	if err.Error() != "Not Found" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+
+	err = &Error{StatusCode: http.StatusBadRequest, Err: errors.New("bad header")}
+	if err.Error() != "Bad Request: bad header" {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
@@ -0,0 +1,175 @@
+package wok
+
+import (
+	"errors"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+const (
+	defaultMaxBodySize = 10 << 20
+	defaultMaxMemory   = 32 << 20
+)
+
+// ErrBodyTooLarge is wrapped by form parsing errors when the request body
+// exceeds the maximum size allowed by the handler
+var ErrBodyTooLarge = errors.New("request body too large")
+
+// bodyTooLarge checks whether the given error comes from reading
+// past the limit of http.MaxBytesReader
+func bodyTooLarge(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "http: request body too large")
+}
+
+// form lazily parses the body of a request, once for every plan
+type form struct {
+	once        sync.Once
+	w           http.ResponseWriter
+	maxBodySize int64
+	maxMemory   int64
+	values      url.Values
+	files       map[string][]*multipart.FileHeader
+	multipart   *multipart.Form
+	err         error
+}
+
+func newForm(w http.ResponseWriter, maxBodySize int64, maxMemory int64) *form {
+	if maxBodySize == 0 {
+		maxBodySize = defaultMaxBodySize
+	}
+
+	if maxMemory == 0 {
+		maxMemory = defaultMaxMemory
+	}
+
+	return &form{
+		w:           w,
+		maxBodySize: maxBodySize,
+		maxMemory:   maxMemory,
+	}
+}
+
+// noForm is never parsed, it's used by requests not built by a handler
+var noForm = &form{}
+
+func (f *form) parse(shared *http.Request) {
+	f.values = url.Values{}
+	f.files = map[string][]*multipart.FileHeader{}
+
+	if shared.Body == nil || shared.Body == http.NoBody {
+		return
+	}
+
+	// The body is parsed through a copy so that the request shared by
+	// every plan is never mutated
+	parsed := *shared
+	r := &parsed
+
+	if f.maxBodySize > 0 {
+		r.Body = http.MaxBytesReader(f.w, r.Body, f.maxBodySize)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var err error
+	if mediaType == "multipart/form-data" {
+		err = r.ParseMultipartForm(f.maxMemory)
+		f.multipart = r.MultipartForm
+	} else {
+		err = r.ParseForm()
+	}
+
+	switch {
+	case bodyTooLarge(err):
+		f.err = &Error{http.StatusRequestEntityTooLarge, ErrBodyTooLarge}
+	case err != nil:
+		f.err = &Error{http.StatusBadRequest, err}
+	}
+
+	for key, values := range r.PostForm {
+		f.values[key] = append([]string{}, values...)
+	}
+
+	if f.multipart != nil {
+		for key, files := range f.multipart.File {
+			f.files[key] = files
+		}
+	}
+}
+
+// cleanup removes the temporary files of multipart forms
+func (f *form) cleanup() {
+	if f == nil || f.multipart == nil {
+		return
+	}
+
+	f.multipart.RemoveAll()
+}
+
+func (r ReadOnlyRequest) parsedForm() *form {
+	if r.form == nil {
+		return noForm
+	}
+
+	r.form.once.Do(func() {
+		r.form.parse(r.Request)
+	})
+
+	return r.form
+}
+
+// PostValues returns the values sent within the body of the request, either
+// URL-encoded or as a multipart form, along with those of the current call.
+// Bodies larger than the limit of the handler fail with ErrBodyTooLarge.
+func (r ReadOnlyRequest) PostValues() (url.Values, error) {
+	f := r.parsedForm()
+
+	values := url.Values{}
+	for key, list := range f.values {
+		values[key] = append([]string{}, list...)
+	}
+
+	for key, list := range r.Call.Values {
+		values[key] = append(values[key], list...)
+	}
+
+	return values, f.err
+}
+
+// Files returns the files uploaded under the given name within a multipart
+// form, which are removed once the request is handled
+func (r ReadOnlyRequest) Files(name string) []*multipart.FileHeader {
+	return r.parsedForm().files[name]
+}
+
+// File returns the first file uploaded under the given name within a
+// multipart form, or nil if there's none
+func (r ReadOnlyRequest) File(name string) *multipart.FileHeader {
+	files := r.Files(name)
+	if len(files) == 0 {
+		return nil
+	}
+
+	return files[0]
+}
+
+// BindForm parses the values returned by PostValues into a new struct
+// described by the provided prototype, see Options.Bind. Fields failing to
+// bind are reported through ParamErrors.
+func (r ReadOnlyRequest) BindForm(prototype interface{}) (interface{}, error) {
+	values, err := r.PostValues()
+	if err != nil {
+		return nil, err
+	}
+
+	bound, _, errs := newBinder(prototype).bind(Params(values))
+	if errs != nil {
+		return nil, errs
+	}
+
+	return bound, nil
+}
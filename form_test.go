@@ -0,0 +1,83 @@
+package wok
+
+import (
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPostValuesBodySize(t *testing.T) {
+	body := "name=abcde" // 10 bytes
+
+	for _, test := range []struct {
+		limit    int64
+		tooLarge bool
+	}{
+		{9, true},
+		{10, false},
+		{11, false},
+	} {
+		r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		request := ReadOnlyRequest{
+			Request: r,
+			form:    newForm(httptest.NewRecorder(), test.limit, 0),
+		}
+
+		values, err := request.PostValues()
+		if test.tooLarge {
+			if !errors.Is(err, ErrBodyTooLarge) {
+				t.Errorf("limit %d: expected ErrBodyTooLarge, got %v", test.limit, err)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("limit %d: unexpected error %v", test.limit, err)
+		} else if values.Get("name") != "abcde" {
+			t.Errorf("limit %d: unexpected values %v", test.limit, values)
+		}
+	}
+}
+
+func TestPostValuesKeepSharedRequest(t *testing.T) {
+	r := httptest.NewRequest("POST", "/", strings.NewReader("name=abcde"))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	request := ReadOnlyRequest{
+		Request: r,
+		form:    newForm(httptest.NewRecorder(), 0, 0),
+	}
+
+	done := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		go func() {
+			values, err := request.PostValues()
+			if err != nil || values.Get("name") != "abcde" {
+				t.Errorf("unexpected result %v, %v", values, err)
+			}
+
+			done <- struct{}{}
+		}()
+	}
+
+	for i := 0; i < 4; i++ {
+		<-done
+	}
+
+	if r.PostForm != nil {
+		t.Errorf("the shared request was mutated: %v", r.PostForm)
+	}
+}
+
+func TestPostValuesWithoutHandler(t *testing.T) {
+	request := ReadOnlyRequest{Request: httptest.NewRequest("GET", "/", nil)}
+
+	values, err := request.PostValues()
+	if err != nil || len(values) != 0 {
+		t.Errorf("unexpected result %v, %v", values, err)
+	}
+}
@@ -0,0 +1,64 @@
+package wok
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Guarded controllers protect themselves and their children with a guard,
+// see Options.Guard
+type Guarded interface {
+	Guard(r Request) error
+}
+
+// ErrDenied is the error wrapped by denials built with Deny
+var ErrDenied = errors.New("access denied")
+
+// Deny builds an error which denies the request with the given status code
+func Deny(statusCode int) error {
+	return &Error{statusCode, ErrDenied}
+}
+
+// Guard protects the plans of this controller and its children, which won't
+// run unless the provided guard allows the request. Returning nil allows it,
+// while returning an error denies it, using the status code of an *Error or
+// 403 otherwise. Guards run before any of these plans, regardless of filters,
+// and may redirect the request through Redirect or URLRedirect. Responses of
+// guarded routes aren't stored by the response cache unless the guard is
+// derived from cacheable options, see Options.Cacheable.
+func (o Options) Guard(fn func(r Request) error) Options {
+	o.linkedPlan = &linkedPlan{
+		parent: o.linkedPlan,
+		plan: Procedure{
+			plans: []plan{
+				{
+					guard:   fn,
+					Options: o,
+				},
+			},
+		},
+	}
+
+	return o
+}
+
+func controllerGuard(controller Controller) (plan, bool) {
+	guarded, ok := controller.(Guarded)
+	if !ok {
+		return plan{}, false
+	}
+
+	return plan{
+		guard:   guarded.Guard,
+		Options: Options{allParams: true},
+	}, true
+}
+
+func guardFailure(err error) *Error {
+	var failure *Error
+	if errors.As(err, &failure) {
+		return failure
+	}
+
+	return &Error{http.StatusForbidden, err}
+}
@@ -0,0 +1,98 @@
+package wok
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manvalls/way"
+	"github.com/manvalls/wit"
+)
+
+func redirectedTree() Handler {
+	authorized := Guard(func(r Request) error {
+		if r.RequestHeader.Get("Authorization") == "" {
+			return Deny(401)
+		}
+
+		return nil
+	})
+
+	return Handler{
+		Root: func() Controller {
+			return NewTree(Cacheable(time.Minute).Run(func(r Request) wit.Command {
+				return text("ROOT")
+			}), map[string]Controller{
+				"a": NewTree(authorized, map[string]Controller{
+					"old": NewTree(Run(func(r Request) wit.Command {
+						r.PartialRedirect(nil, "new")
+						return wit.Nil
+					}), nil),
+					"new": NewTree(Cacheable(time.Minute).Run(func(r Request) wit.Command {
+						return text("SECRET")
+					}), nil),
+				}),
+			})
+		},
+		Router:        way.BuildRouter(way.RouteMap{"/a/old": {"a", "old"}, "/a/new": {"a", "new"}}),
+		ResponseCache: NewMemoryResponseCache(),
+	}
+}
+
+func TestGuardSurvivesRedirections(t *testing.T) {
+	h := redirectedTree()
+
+	w := serve(h, "GET", "/a/old", "X-Wok-Instance-ID", "client", "Authorization", "token")
+	expectStatus(t, w, 200)
+	if !strings.Contains(w.Body.String(), "SECRET") {
+		t.Fatalf("unexpected response %s", w.Body.String())
+	}
+
+	w = serve(h, "GET", "/a/old", "X-Wok-Instance-ID", "client")
+	expectStatus(t, w, 401)
+	if strings.Contains(w.Body.String(), "SECRET") {
+		t.Errorf("guarded response served from cache: %s", w.Body.String())
+	}
+}
+
+func guardedTree(guard func(r Request) error) Handler {
+	return Handler{
+		Root: func() Controller {
+			return NewTree(Run(func(r Request) wit.Command {
+				return text("PUBLIC")
+			}), map[string]Controller{
+				"a": NewTree(List(Guard(guard), Run(func(r Request) wit.Command {
+					return text("PRIVATE")
+				})), nil),
+			})
+		},
+		Router: way.BuildRouter(way.RouteMap{"/a": {"a"}}),
+	}
+}
+
+func TestGuardDenies(t *testing.T) {
+	for _, test := range []struct {
+		err        error
+		statusCode int
+	}{
+		{nil, 200},
+		{Deny(401), 401},
+		{errors.New("nope"), 403},
+	} {
+		w := serve(guardedTree(func(r Request) error {
+			return test.err
+		}), "GET", "/a")
+
+		expectStatus(t, w, test.statusCode)
+
+		body := w.Body.String()
+		if !strings.Contains(body, "PUBLIC") {
+			t.Errorf("%v: plans above the guard didn't run: %s", test.err, body)
+		}
+
+		if strings.Contains(body, "PRIVATE") != (test.err == nil) {
+			t.Errorf("%v: unexpected response %s", test.err, body)
+		}
+	}
+}
@@ -2,8 +2,11 @@ package wok
 
 import (
 	"context"
+	"net/http"
 	"sync"
+	"time"
 
+	"github.com/manvalls/way"
 	"github.com/manvalls/wit"
 )
 
@@ -16,6 +19,16 @@ type planInfo struct {
 	params    Params
 	oldParams Params
 	command   wit.Command
+	streamed  bool
+	suspended bool
+	started   bool
+	finished  bool
+	detached  bool
+	cyclic    bool
+	required  bool
+	result    interface{}
+	providers map[string]*planInfo
+	scope     *serviceScope
 }
 
 func getOffset(previousRoute []string, newRoute []string) (offset int) {
@@ -30,20 +43,31 @@ func getOffset(previousRoute []string, newRoute []string) (offset int) {
 	return
 }
 
-func paramsMatch(params Params, usedParams Params) bool {
-	paramsList := make([]string, len(usedParams))
-
-	i := 0
-	for key := range usedParams {
-		paramsList[i] = key
-		i++
+func pickParams(params Params, paramsList []string) Params {
+	result := make(Params, len(paramsList))
+	for _, param := range paramsList {
+		if value, ok := params[param]; ok {
+			result[param] = value
+		}
 	}
 
-	return paramsChanged(params, usedParams, paramsList)
+	return result
 }
 
-func pickParams(params Params, paramsList []string) Params {
-	return params
+func allParams(paramsMaps ...Params) []string {
+	seen := map[string]bool{}
+	paramsList := []string{}
+
+	for _, params := range paramsMaps {
+		for param := range params {
+			if !seen[param] {
+				seen[param] = true
+				paramsList = append(paramsList, param)
+			}
+		}
+	}
+
+	return paramsList
 }
 
 func paramsChanged(oldParams Params, newParams Params, paramsList []string) bool {
@@ -65,6 +89,19 @@ func paramsChanged(oldParams Params, newParams Params, paramsList []string) bool
 	return false
 }
 
+func mergeParams(params Params, changes Params) Params {
+	result := cloneParams(params)
+	for key, value := range changes {
+		if len(value) == 0 {
+			delete(result, key)
+		} else {
+			result[key] = value
+		}
+	}
+
+	return result
+}
+
 func cloneParams(params Params) Params {
 	result := make(Params)
 	for key, value := range params {
@@ -91,6 +128,32 @@ func getDeps(request *Request) []string {
 	return list
 }
 
+func runWithTimeout(fn func(r Request) wit.Command, r Request, timeout time.Duration, fallback wit.Command) wit.Command {
+	if timeout <= 0 {
+		return fn(r)
+	}
+
+	result := make(chan wit.Command, 1)
+	go func() {
+		result <- fn(r)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case command := <-result:
+		if r.Err() == context.DeadlineExceeded {
+			// The plan returned because of the timeout
+			return fallback
+		}
+
+		return command
+	case <-timer.C:
+		return fallback
+	}
+}
+
 // Controller represents a controller of the routing tree
 type Controller interface {
 	Plan() Plan
@@ -110,12 +173,50 @@ func (controller Default) Resolve(id string) Controller {
 	return Default{}
 }
 
+type notFound struct{}
+
+func (controller notFound) Plan() Plan {
+	return Nil
+}
+
+func (controller notFound) Resolve(id string) Controller {
+	return controller
+}
+
+// NotFound is returned by controllers which can't resolve a child, stopping
+// the resolution of the route with a 404 status code
+var NotFound Controller = notFound{}
+
 // HandleOptions wraps request.Handle options
 type HandleOptions struct {
 	Root       Controller
 	HeaderName string
 	Route      []string
 	Params
+
+	// Services are made available to every plan
+	Services Services
+
+	// NotFound is applied at the level where the route couldn't be resolved
+	NotFound Plan
+
+	// ErrorPlan is applied at the level where a guard denied the request
+	ErrorPlan Plan
+
+	// Pools limit the number of non-sync plans running at the same time
+	Pools []*Pool
+
+	// PlanTimeout bounds the execution time of plans which don't
+	// define their own timeout
+	PlanTimeout time.Duration
+
+	// PlanCache stores the commands of cached plans,
+	// DefaultPlanCache being used if not provided
+	PlanCache PlanCache
+
+	// Stream, when provided, receives the commands of streamed and suspended
+	// plans which didn't finish before the rest of the plans
+	Stream func(wit.Command)
 }
 
 // Handle executes the appropiate plans and gathers returned commands
@@ -132,10 +233,80 @@ func (r Request) Handle(o HandleOptions) (wit.Command, func()) {
 	oldParams, oldRoute := r.FromHeader(o.HeaderName)
 	redirectionOffset := 0
 	running := 0
+	detachable := 0
+
+	complete := func(info *planInfo, command wit.Command) {
+		cond.L.Lock()
+		if info.finished {
+			cond.L.Unlock()
+			return
+		}
+
+		info.command = command
+		info.finished = true
+		detached := info.detached
+
+		running--
+		if info.streamed || info.suspended {
+			detachable--
+		}
+
+		cond.Broadcast()
+		cond.L.Unlock()
+
+		if info.streamed || info.suspense > 0 {
+			if detached && o.Stream != nil {
+				o.Stream(command)
+			}
+
+			wg.Done()
+		}
+	}
+
+	suspend := func(info *planInfo) {
+		cond.L.Lock()
+		defer cond.L.Unlock()
+
+		if info.finished || info.suspended || o.Stream == nil {
+			return
+		}
+
+		info.suspended = true
+		if !info.streamed {
+			detachable++
+		}
+
+		cond.Broadcast()
+	}
+
+	// The failure of routes which couldn't be resolved, along with the status
+	// code and failure it replaced, which are restored on redirections
+	var missingFailure *Error
+	var previousStatusCode int
+	var previousFailure error
+
+	// Plans which don't need to run, unless needed by the ones which do
+	skipped := []*planInfo{}
+
+	// Guards checked by previous iterations which still apply
+	checkedGuards := []*planInfo{}
 
 mainLoop:
 	for i := 0; i <= maxRedirections; i++ {
+		if missingFailure != nil {
+			if r.Failure == error(missingFailure) {
+				r.Failure = previousFailure
+			}
+
+			if r.StatusCode() == missingFailure.StatusCode {
+				r.SetStatusCode(previousStatusCode)
+			}
+
+			missingFailure = nil
+		}
+
 		plansToRun := []*planInfo{}
+		guards := []*planInfo{}
 		oldPlansInfo := plansInfo
 		plansInfo = []*planInfo{}
 
@@ -145,6 +316,22 @@ mainLoop:
 			minOffset = redirectionOffset
 		}
 
+		oldSkipped := skipped
+		skipped = []*planInfo{}
+		for _, info := range oldSkipped {
+			if info.offset < redirectionOffset {
+				skipped = append(skipped, info)
+			}
+		}
+
+		oldGuards := checkedGuards
+		checkedGuards = []*planInfo{}
+		for _, guard := range oldGuards {
+			if guard.offset < redirectionOffset {
+				checkedGuards = append(checkedGuards, guard)
+			}
+		}
+
 		for _, info := range oldPlansInfo {
 			if info.offset >= minOffset {
 				if info.CancelFunc != nil {
@@ -154,13 +341,21 @@ mainLoop:
 				continue
 			}
 
-			if !paramsMatch(params, info.params) {
+			if info.plan.paramsChanged(info.params, params) {
 				if info.CancelFunc != nil {
 					info.CancelFunc()
 				}
 
-				if info.handler || paramsChanged(oldParams, params, info.plan.params) {
-					plansToRun = append(plansToRun, info)
+				rerun := &planInfo{
+					plan:   info.plan,
+					offset: info.offset,
+					scope:  info.scope,
+				}
+
+				if info.handler || info.plan.paramsChanged(oldParams, params) {
+					plansToRun = append(plansToRun, rerun)
+				} else {
+					skipped = append(skipped, rerun)
 				}
 				continue
 			}
@@ -170,23 +365,75 @@ mainLoop:
 
 		if redirectionOffset < len(route) {
 			controller := o.Root
+			scope := &serviceScope{services: o.Services}
+
 			for i := 1; i < redirectionOffset; i++ {
+				scope = scope.extend(controller.Plan())
 				controller = controller.Resolve(route[i])
 			}
 
+			if redirectionOffset > 0 {
+				scope = scope.extend(controller.Plan())
+			}
+
 			for i := redirectionOffset; i < len(route); i++ {
 				if i != 0 {
 					controller = controller.Resolve(route[i])
 				}
 
-				for _, c := range controller.Plan().Procedure().plans {
-					if c.handler || c.deps != nil || i >= offset || paramsChanged(oldParams, params, c.params) {
-						plansToRun = append(plansToRun, &planInfo{
+				plan := controller.Plan()
+				_, missing := controller.(notFound)
+				if missing {
+					previousStatusCode = r.StatusCode()
+					previousFailure = r.Failure
+					missingFailure = &Error{http.StatusNotFound, way.ErrNotFound}
+
+					r.SetStatusCode(missingFailure.StatusCode)
+					r.Failure = missingFailure
+					plan = o.NotFound
+				}
+
+				scope = scope.extend(plan)
+
+				if guard, ok := controllerGuard(controller); ok {
+					guards = append(guards, &planInfo{
+						plan:   guard,
+						offset: i,
+						scope:  scope,
+					})
+				}
+
+				if plan == nil {
+					break
+				}
+
+				for _, c := range plan.Procedure().plans {
+					if c.guard != nil {
+						guards = append(guards, &planInfo{
 							plan:   c,
 							offset: i,
+							scope:  scope,
 						})
+
+						continue
+					}
+
+					info := &planInfo{
+						plan:   c,
+						offset: i,
+						scope:  scope,
+					}
+
+					if c.handler || c.deps != nil || i >= offset || c.paramsChanged(oldParams, params) {
+						plansToRun = append(plansToRun, info)
+					} else {
+						skipped = append(skipped, info)
 					}
 				}
+
+				if missing {
+					break
+				}
 			}
 		}
 
@@ -210,12 +457,97 @@ mainLoop:
 			}
 		}
 
+		for _, guard := range guards {
+			subRequest := r
+			subRequest.redirectCond = cond
+			subRequest.redirectedRoute = &redirectedRoute
+			subRequest.redirectedParams = &redirectedParams
+
+			subRequest.route = route
+			subRequest.index = guard.offset
+
+			subRequest.fullParams = params
+			subRequest.Values = cloneParams(guard.plan.pickParams(params))
+			subRequest.OldParams = cloneParams(guard.plan.pickParams(oldParams))
+			subRequest.services = guard.scope
+
+			cond.L.Unlock()
+			err := guard.guard(subRequest)
+			cond.L.Lock()
+
+			r.customMutex.Lock()
+
+			if *r.custom {
+				r.customMutex.Unlock()
+				break mainLoop
+			}
+
+			r.customMutex.Unlock()
+
+			checkRedirections()
+
+			if redirectionHandled {
+				if redirectionOffset > guard.offset {
+					redirectionOffset = guard.offset
+				}
+
+				break
+			}
+
+			if err == nil {
+				continue
+			}
+
+			failure := guardFailure(err)
+			r.SetStatusCode(failure.StatusCode)
+			r.Failure = failure
+
+			allowedPlans := []*planInfo{}
+			for _, info := range plansToRun {
+				if info.offset < guard.offset {
+					allowedPlans = append(allowedPlans, info)
+				}
+			}
+
+			allowedInfo := []*planInfo{}
+			for _, info := range plansInfo {
+				if info.offset < guard.offset {
+					allowedInfo = append(allowedInfo, info)
+				} else if info.CancelFunc != nil {
+					info.CancelFunc()
+				}
+			}
+
+			for _, c := range always(o.ErrorPlan).plans {
+				if c.guard == nil {
+					allowedPlans = append(allowedPlans, &planInfo{
+						plan:   c,
+						offset: guard.offset,
+						scope:  guard.scope,
+					})
+				}
+			}
+
+			plansToRun = allowedPlans
+			plansInfo = allowedInfo
+			break
+		}
+
+		checkedGuards = append(checkedGuards, guards...)
+		plansToRun, skipped = requireProviders(plansToRun, plansInfo, skipped)
+		plansToRun = schedulePlans(plansToRun, plansInfo)
+
 	plansLoop:
 		for _, info := range plansToRun {
 			if redirectionHandled && info.offset >= redirectionOffset {
 				continue
 			}
 
+			if info.cyclic {
+				r.SetStatusCode(http.StatusInternalServerError)
+				continue
+			}
+
 			if r.IsSocket && info.socket == falseField {
 				continue
 			}
@@ -248,6 +580,11 @@ mainLoop:
 				continue
 			}
 
+			if failure := r.rateLimit(info); failure != nil {
+				r.Failure = failure
+				continue
+			}
+
 			if info.exclusive {
 				for running > 0 {
 					cond.Wait()
@@ -269,14 +606,152 @@ mainLoop:
 				}
 			}
 
-			info.params = pickParams(params, info.plan.params)
-			info.oldParams = pickParams(oldParams, info.plan.params)
+			fn := info.plan.fn
+			doFn := info.plan.doFn
+
+			if fn != nil && info.planCache != nil && !r.IsSocket {
+				planCache := o.PlanCache
+				if planCache == nil {
+					planCache = DefaultPlanCache
+				}
+
+				fn = info.planCache.cached(planCache, fn)
+			}
+			var bound interface{}
+
+			if info.binder != nil && (fn != nil || doFn != nil) {
+				var corrected Params
+				var errs ParamErrors
+
+				bound, corrected, errs = info.binder.bind(info.plan.pickParams(params))
+				if errs != nil {
+					if info.correct && corrected != nil {
+						if redirectedRoute == nil && redirectedParams == nil {
+							redirectedParams = mergeParams(params, corrected)
+							checkRedirections()
+						}
+
+						continue
+					}
+
+					r.SetStatusCode(http.StatusBadRequest)
+					if info.onInvalid == nil || fn == nil {
+						continue
+					}
+
+					invalidParams := info.onInvalid
+					fn = func(r Request) wit.Command {
+						return invalidParams(r, errs)
+					}
+				}
+			}
+
+			inline := info.sync
+			release := func() {}
+
+			timeout := info.timeout
+			if timeout == 0 {
+				timeout = o.PlanTimeout
+			}
+
+			if (info.fn != nil || info.doFn != nil) && !info.sync && len(o.Pools) > 0 {
+				acquireCtx := r.Context
+				cancelAcquire := func() {}
+				start := time.Now()
+
+				if timeout > 0 {
+					acquireCtx, cancelAcquire = context.WithTimeout(r.Context, timeout)
+				}
+
+				cond.L.Unlock()
+				releasePools, err := acquirePools(acquireCtx, o.Pools)
+				cond.L.Lock()
+
+				cancelAcquire()
+
+				if err != nil {
+					if info.fn != nil && r.Context.Err() == nil {
+						info.params = info.plan.pickParams(params)
+						info.oldParams = info.plan.pickParams(oldParams)
+						info.command = info.fallback
+						info.started = true
+						info.finished = true
+						plansInfo = append(plansInfo, info)
+						cond.Broadcast()
+					}
+
+					continue
+				}
+
+				if timeout > 0 {
+					timeout -= time.Since(start)
+					if timeout <= 0 {
+						timeout = time.Nanosecond
+					}
+				}
+
+				if releasePools == nil {
+					inline = true
+				} else {
+					once := sync.Once{}
+					release = func() {
+						once.Do(releasePools)
+					}
+				}
+
+				r.customMutex.Lock()
+
+				if *r.custom {
+					r.customMutex.Unlock()
+					release()
+					break mainLoop
+				}
+
+				r.customMutex.Unlock()
+
+				checkRedirections()
+
+				if redirectionHandled && info.offset >= redirectionOffset {
+					release()
+					continue
+				}
+			}
+
+			if inline {
+				for info.waiting() {
+					cond.Wait()
+
+					r.customMutex.Lock()
+
+					if *r.custom {
+						r.customMutex.Unlock()
+						break mainLoop
+					}
+
+					r.customMutex.Unlock()
+
+					checkRedirections()
+
+					if redirectionHandled && info.offset >= redirectionOffset {
+						continue plansLoop
+					}
+				}
+			}
+
+			info.params = info.plan.pickParams(params)
+			info.oldParams = info.plan.pickParams(oldParams)
 			info.command = info.plan.command
+			info.started = true
+			info.finished = fn == nil && doFn == nil
 			plansInfo = append(plansInfo, info)
 
 			if info.fn != nil || info.doFn != nil {
 				subRequest := r
-				subRequest.Context, info.CancelFunc = context.WithCancel(r.Context)
+				if timeout > 0 {
+					subRequest.Context, info.CancelFunc = context.WithTimeout(r.Context, timeout)
+				} else {
+					subRequest.Context, info.CancelFunc = context.WithCancel(r.Context)
+				}
 
 				subRequest.redirectCond = cond
 				subRequest.redirectedRoute = &redirectedRoute
@@ -285,15 +760,24 @@ mainLoop:
 				subRequest.route = route
 				subRequest.index = info.offset
 
+				subRequest.fullParams = params
 				subRequest.Values = cloneParams(info.params)
 				subRequest.OldParams = cloneParams(info.oldParams)
+				subRequest.Bound = bound
+
+				subRequest.current = info
+				subRequest.providers = info.providers
+				subRequest.services = info.scope
 
 				if info.fn != nil {
-					if info.sync {
+					if inline {
 						cond.L.Unlock()
-						info.command = info.plan.fn(subRequest)
+						info.command = runWithTimeout(fn, subRequest, timeout, info.fallback)
 						cond.L.Lock()
 
+						info.finished = true
+						cond.Broadcast()
+
 						r.customMutex.Lock()
 
 						if *r.custom {
@@ -307,22 +791,81 @@ mainLoop:
 					} else {
 						running++
 
-						go func(info *planInfo) {
-							info.command = info.plan.fn(subRequest)
+						info.streamed = info.stream && o.Stream != nil
+						if info.streamed {
+							detachable++
+						}
 
+						if info.streamed || info.suspense > 0 {
+							wg.Add(1)
+						}
+
+						timedInfo := info
+
+						var timeoutTimer *time.Timer
+						if timeout > 0 {
+							timeoutTimer = time.AfterFunc(timeout, func() {
+								complete(timedInfo, timedInfo.fallback)
+								release()
+							})
+						}
+
+						var suspenseTimer *time.Timer
+						if info.suspense > 0 && o.Stream != nil {
+							suspenseTimer = time.AfterFunc(info.suspense, func() {
+								suspend(timedInfo)
+							})
+						}
+
+						go func(info *planInfo) {
 							cond.L.Lock()
-							running--
-							cond.Broadcast()
+							for info.waiting() {
+								cond.Wait()
+							}
 							cond.L.Unlock()
+
+							command := fn(subRequest)
+							release()
+
+							if timeoutTimer != nil {
+								timeoutTimer.Stop()
+								if subRequest.Err() == context.DeadlineExceeded {
+									command = info.fallback
+								}
+							}
+
+							if suspenseTimer != nil {
+								suspenseTimer.Stop()
+							}
+
+							complete(info, command)
 						}(info)
 					}
 				} else {
-					if info.sync {
-						info.plan.doFn(subRequest.ReadOnlyRequest)
+					if inline {
+						cond.L.Unlock()
+						doFn(subRequest.ReadOnlyRequest)
+						cond.L.Lock()
+
+						info.finished = true
+						cond.Broadcast()
 					} else {
 						wg.Add(1)
 						go func(info *planInfo) {
-							info.plan.doFn(subRequest.ReadOnlyRequest)
+							cond.L.Lock()
+							for info.waiting() {
+								cond.Wait()
+							}
+							cond.L.Unlock()
+
+							doFn(subRequest.ReadOnlyRequest)
+							release()
+
+							cond.L.Lock()
+							info.finished = true
+							cond.Broadcast()
+							cond.L.Unlock()
+
 							wg.Done()
 						}(info)
 					}
@@ -330,7 +873,7 @@ mainLoop:
 			}
 		}
 
-		for redirectedRoute == nil && redirectedParams == nil && running > 0 {
+		for redirectedRoute == nil && redirectedParams == nil && running > detachable {
 			cond.Wait()
 
 			r.customMutex.Lock()
@@ -366,6 +909,13 @@ mainLoop:
 					}
 
 					commandList[i] = wit.List(depsCommands...)
+				} else if info.required {
+					continue
+				} else if info.fn != nil && !info.finished && (info.streamed || info.suspended) {
+					info.detached = true
+					if info.suspended {
+						commandList[i] = info.placeholder
+					}
 				} else if info.doFn == nil {
 					commandList[i] = info.command
 				}
@@ -385,6 +935,7 @@ mainLoop:
 			}()
 
 			r.ContextVary(o.HeaderName)
+			r.validators.setPlans(plansInfo, checkedGuards)
 			return wit.List(commandList...), func() {
 				wg.Wait()
 			}
@@ -1,15 +1,16 @@
 package wok
 
 import (
+	"bytes"
 	"math/rand"
 	"net/http"
 	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
-	"github.com/golang/gddo/httputil"
 	"github.com/gorilla/websocket"
 	"github.com/manvalls/way"
 	"github.com/manvalls/wit"
@@ -18,12 +19,35 @@ import (
 
 // Handler implements an HTTP handler which provides wok requests
 type Handler struct {
-	Root             func() Controller
-	RootName         string
-	RouteHeader      string
-	DepsHeader       string
-	InstanceIDHeader string
-	InputBuffer      int
+	Root              func() Controller
+	RootName          string
+	RouteHeader       string
+	DepsHeader        string
+	InstanceIDHeader  string
+	InputBuffer       int
+	MaxBodySize       int64
+	MaxMemory         int64
+	IdempotencyHeader string
+	IdempotencyStore  IdempotencyStore
+	IdempotencyScope  func(r *http.Request) string
+	IdempotencyTTL    time.Duration
+	EventLimiter      Limiter
+	EventLimitBy      []RateDimension
+	ResponseCache     ResponseCache
+	PlanCache         PlanCache
+	Compress          bool
+	CompressMinSize   int
+	Locales           []string
+	LocaleParam       string
+	LocaleCookie      string
+	Catalog           Catalog
+	Formats           []Format
+	PlanTimeout       time.Duration
+	Pool              *Pool
+	RequestPool       func() *Pool
+	NotFound          Plan
+	ErrorPlan         Plan
+	Services          Services
 	websocket.Upgrader
 	way.Router
 }
@@ -45,10 +69,70 @@ var randPool = sync.Pool{
 }
 
 func (h Handler) serve(w http.ResponseWriter, r *http.Request, input <-chan url.Values, output chan<- wit.Command, flush func()) {
+	var gz *gzipWriter
+	compress := h.Compress && input == nil
+	if compress && acceptsGzip(r) {
+		gz = newGzipWriter(w, h.CompressMinSize)
+		defer gz.close()
+		w = gz
+	}
+
+	if key := h.idempotencyKey(r); key != "" {
+		ttl := h.IdempotencyTTL
+		if ttl == 0 {
+			ttl = defaultIdempotencyTTL
+		}
+
+		response, claimed := h.IdempotencyStore.Claim(key, ttl)
+		if !claimed {
+			if response != nil {
+				w.Header().Set("Idempotent-Replayed", "true")
+				response.replay(w)
+			} else {
+				w.WriteHeader(http.StatusConflict)
+			}
+
+			if flush != nil {
+				flush()
+			}
+
+			return
+		}
+
+		recorder := &responseRecorder{ResponseWriter: w}
+		w = recorder
+
+		defer func() {
+			if p := recover(); p != nil {
+				h.IdempotencyStore.Release(key)
+				panic(p)
+			}
+
+			response := recorder.response()
+			if response.StatusCode >= 500 {
+				h.IdempotencyStore.Release(key)
+			} else {
+				h.IdempotencyStore.Set(key, response, ttl)
+			}
+		}()
+	}
+
+	errorPlan := h.ErrorPlan
+	if errorPlan == nil {
+		errorPlan = h.NotFound
+	}
+
+	var failure *Error
+
 	params, route, err := h.GetRoute(r.URL)
 	if err != nil {
-		w.WriteHeader(404)
-		return
+		if errorPlan == nil {
+			w.WriteHeader(404)
+			return
+		}
+
+		failure = &Error{http.StatusNotFound, err}
+		params = r.URL.Query()
 	}
 
 	route = append([]string{h.RootName}, route...)
@@ -68,6 +152,27 @@ func (h Handler) serve(w http.ResponseWriter, r *http.Request, input <-chan url.
 		instanceIDHeader = "X-Wok-Instance-ID"
 	}
 
+	var cacheRecorder *responseRecorder
+	if h.ResponseCache != nil && input == nil && (r.Method == http.MethodGet || r.Method == http.MethodHead) &&
+		r.Header.Get(instanceIDHeader) != "" && r.Header.Get("X-Wok-Call") == "" {
+
+		if response, ok := h.cachedResponse(r); ok {
+			etag := response.Header.Get("ETag")
+			lastModified, _ := http.ParseTime(response.Header.Get("Last-Modified"))
+			if (etag != "" || !lastModified.IsZero()) && notModified(r, etag, lastModified) {
+				copyHeader(w.Header(), response.Header)
+				w.WriteHeader(http.StatusNotModified)
+			} else {
+				response.replay(w)
+			}
+
+			return
+		}
+
+		cacheRecorder = &responseRecorder{ResponseWriter: w}
+		w = cacheRecorder
+	}
+
 	var instanceCmd wit.Command
 	instanceID := r.Header.Get(instanceIDHeader)
 	if instanceID == "" {
@@ -108,6 +213,9 @@ func (h Handler) serve(w http.ResponseWriter, r *http.Request, input <-chan url.
 			Input:         input,
 			Output:        output,
 			Mutex:         &sync.Mutex{},
+
+			loaders: newLoaders(r.Context()),
+			form:    newForm(w, h.MaxBodySize, h.MaxMemory),
 		},
 
 		StatusCodeGetterSetter: &StatusCodeGetterSetter{},
@@ -122,17 +230,42 @@ func (h Handler) serve(w http.ResponseWriter, r *http.Request, input <-chan url.
 		vary:      make(map[string]int),
 		varyMutex: &sync.Mutex{},
 
+		limits:      make(map[rateLimitKey]*Error),
+		limitsMutex: &sync.Mutex{},
+
+		validators: newValidators(),
+
 		deduper: &deduper{
 			indexedDeduperElements: make(map[string]*deduperElement),
 			deduperMutex:           &sync.Mutex{},
 		},
 	}
 
+	request.Vary("X-Requested-With", "X-Navigation")
+
+	if compress {
+		request.Vary("Accept-Encoding")
+	}
+
+	if len(h.Locales) > 0 {
+		locale, vary := h.negotiateLocale(r, params)
+		request.Locale = locale
+		request.catalog = h.Catalog
+		request.defaultLocale = h.Locales[0]
+		request.Vary(vary...)
+		w.Header().Set("Content-Language", locale)
+	}
+
 	callParts := strings.SplitN(r.Header.Get("X-Wok-Call"), "?", 2)
 
 	switch len(callParts) {
 	case 2:
-		request.Call.Values, _ = url.ParseQuery(callParts[1])
+		request.Call.Values, err = url.ParseQuery(callParts[1])
+		if err != nil && failure == nil && h.ErrorPlan != nil {
+			failure = &Error{http.StatusBadRequest, err}
+			errorPlan = h.ErrorPlan
+		}
+
 		fallthrough
 	case 1:
 		request.Call.Name = callParts[0]
@@ -147,12 +280,51 @@ func (h Handler) serve(w http.ResponseWriter, r *http.Request, input <-chan url.
 
 	request.loadedDependencies = depsMap
 
-	delta, doWait := request.Handle(HandleOptions{
-		Root:       h.Root(),
+	format := negotiateFormat(r, h.Formats)
+
+	var stream *patchStream
+	if request.IsSocket {
+		stream = newPatchStream(func(command wit.Command) {
+			request.Send(command)
+		})
+	} else if format.NewPatchRenderer != nil && r.Method != http.MethodHead {
+		stream = newWriterPatchStream(w, format)
+	}
+
+	root := h.Root()
+	if failure != nil {
+		request.Failure = failure
+		request.SetStatusCode(failure.StatusCode)
+		root = errorRoot{root, errorPlan}
+		route = route[:1]
+	}
+
+	handleOptions := HandleOptions{
+		Root:       root,
 		HeaderName: routeHeader,
 		Params:     params,
 		Route:      route,
-	})
+
+		PlanTimeout: h.PlanTimeout,
+		NotFound:    h.NotFound,
+		ErrorPlan:   h.ErrorPlan,
+		Services:    h.Services,
+		PlanCache:   h.PlanCache,
+	}
+
+	if h.RequestPool != nil {
+		handleOptions.Pools = append(handleOptions.Pools, h.RequestPool())
+	}
+
+	if h.Pool != nil {
+		handleOptions.Pools = append(handleOptions.Pools, h.Pool)
+	}
+
+	if stream != nil {
+		handleOptions.Stream = stream.push
+	}
+
+	delta, doWait := request.Handle(handleOptions)
 
 	if instanceCmd != nil {
 		delta = wit.List(delta, instanceCmd)
@@ -176,83 +348,170 @@ func (h Handler) serve(w http.ResponseWriter, r *http.Request, input <-chan url.
 		delta = wit.List(delta, wit.Head.One(wit.Append(wit.FromString(script))))
 	}
 
-	request.varyMutex.Lock()
-	defer request.varyMutex.Unlock()
+	patchTail := ""
+
+	func() {
+		request.varyMutex.Lock()
+		defer request.varyMutex.Unlock()
+
+		resHeaders := w.Header()
+		private := false
+		for header, n := range request.vary {
+			if n > 0 {
+				resHeaders["Vary"] = append(resHeaders["Vary"], header)
 
-	resHeaders := w.Header()
-	for header, n := range request.vary {
-		if n > 0 {
-			resHeaders["Vary"] = append(resHeaders["Vary"], header)
+				header = http.CanonicalHeaderKey(header)
+				private = private || header == http.CanonicalHeaderKey(routeHeader) || header == http.CanonicalHeaderKey(depsHeader)
+			}
 		}
-	}
 
-	request.customMutex.Lock()
-	defer request.customMutex.Unlock()
+		request.customMutex.Lock()
+		defer request.customMutex.Unlock()
 
-	if !custom {
-		request.routesMutex.Lock()
-		defer request.routesMutex.Unlock()
+		if !custom {
+			request.routesMutex.Lock()
+			defer request.routesMutex.Unlock()
 
-		routes := map[string]string{}
-		for _, hv := range request.routes {
-			routes[hv.header] = hv.value
-		}
+			routes := map[string]string{}
+			for _, hv := range request.routes {
+				routes[hv.header] = hv.value
+			}
+
+			if request.IsNavigation {
+				script := "<script data-w-rm>(function(){(window.SPH=window.SPASessionHeadersMap=window.SPH||window.SPASessionHeadersMap||{}).routes={"
 
-		if request.IsNavigation {
-			script := "<script data-w-rm>(function(){(window.SPH=window.SPASessionHeadersMap=window.SPH||window.SPASessionHeadersMap||{}).routes={"
+				i := 0
+				for key, value := range routes {
+					if i != 0 {
+						script += ","
+					}
 
-			i := 0
-			for key, value := range routes {
-				if i != 0 {
-					script += ","
+					script += strconv.Quote(key) + ":" + strconv.Quote(value)
+					i++
 				}
 
-				script += strconv.Quote(key) + ":" + strconv.Quote(value)
-				i++
+				script += "}})()</script>"
+
+				delta = wit.List(delta, wit.Head.One(wit.Append(wit.FromString(script))))
 			}
 
-			script += "}})()</script>"
+			resHeaders["Vary"] = append(resHeaders["Vary"], "Accept")
+			varyHeaders := append([]string{}, resHeaders["Vary"]...)
+			resHeaders["Vary"] = []string{strings.Join(resHeaders["Vary"], ", ")}
 
-			delta = wit.List(delta, wit.Head.One(wit.Append(wit.FromString(script))))
-		}
+			resHeaders["Content-Type"] = []string{format.ContentType()}
+			statusCode := request.StatusCode()
 
-		contentType := httputil.NegotiateContentType(r, []string{"text/html", "application/json"}, "text/html")
+			if statusCode == http.StatusOK && (r.Method == http.MethodGet || r.Method == http.MethodHead) && instanceCmd == nil && !request.IsSocket {
+				sort.Strings(varyHeaders)
+				values := []string{format.ContentType()}
+				for _, header := range varyHeaders {
+					values = append(values, header+": "+strings.Join(r.Header[http.CanonicalHeaderKey(header)], ","))
+				}
 
-		var renderer wit.Renderer
-		if contentType == "application/json" {
-			renderer = wit.NewJSONRenderer(delta)
-		} else {
-			contentType += "; charset=utf-8"
-			renderer = wit.NewHTMLRenderer(delta)
-		}
+				if etag, lastModified, ok := request.validators.compute(values...); ok {
+					resHeaders.Set("ETag", etag)
+					if !lastModified.IsZero() {
+						resHeaders.Set("Last-Modified", lastModified.UTC().Format(http.TimeFormat))
+					}
+
+					if resHeaders.Get("Cache-Control") == "" {
+						if private {
+							resHeaders.Set("Cache-Control", "private, no-cache")
+						} else {
+							resHeaders.Set("Cache-Control", "no-cache")
+						}
+					}
+
+					if notModified(r, etag, lastModified) {
+						if stream != nil {
+							stream.close()
+						}
+
+						w.WriteHeader(http.StatusNotModified)
+						return
+					}
+				}
+			}
+
+			w.WriteHeader(statusCode)
+			if r.Method == http.MethodHead {
+				if gz != nil {
+					var size byteCounter
+					format.NewRenderer(delta).Render(&size)
+					gz.omit(int(size))
+				}
 
-		resHeaders["Vary"] = append(resHeaders["Vary"], "Accept")
-		resHeaders["Vary"] = []string{strings.Join(resHeaders["Vary"], ", ")}
+				return
+			}
 
-		resHeaders["Content-Type"] = []string{contentType}
-		w.WriteHeader(request.StatusCode())
-		renderer.Render(w)
-	} else {
-		resHeaders["Vary"] = []string{strings.Join(resHeaders["Vary"], ", ")}
-		if customHandler != nil {
-			customHandler(w)
+			if stream == nil || request.IsSocket || !request.validators.detached() {
+				format.NewRenderer(delta).Render(w)
+				return
+			}
+
+			if format.PatchClient != nil {
+				delta = wit.List(delta, format.PatchClient)
+			}
+
+			buff := bytes.Buffer{}
+			format.NewRenderer(delta).Render(&buff)
+
+			rendered := buff.Bytes()
+			if format.PatchTail != "" && bytes.HasSuffix(rendered, []byte(format.PatchTail)) {
+				rendered = rendered[:len(rendered)-len(format.PatchTail)]
+				patchTail = format.PatchTail
+			}
+
+			w.Write(rendered)
 		} else {
-			w.WriteHeader(request.StatusCode())
+			if stream != nil {
+				stream.close()
+			}
+
+			resHeaders["Vary"] = []string{strings.Join(resHeaders["Vary"], ", ")}
+			if customHandler != nil {
+				customHandler(w)
+			} else {
+				w.WriteHeader(request.StatusCode())
+			}
 		}
-	}
+	}()
 
 	if flush != nil {
 		flush()
+	} else if flusher, ok := w.(http.Flusher); ok && stream != nil {
+		flusher.Flush()
+	}
+
+	if stream != nil {
+		stream.start()
 	}
 
 	doWait()
+	request.form.cleanup()
+
+	if patchTail != "" {
+		stream.close()
+		w.Write([]byte(patchTail))
+	}
+
+	if cacheRecorder != nil {
+		request.customMutex.Lock()
+		cacheable := !custom
+		request.customMutex.Unlock()
+
+		if ttl, tags, ok := request.validators.cacheability(); ok && cacheable {
+			h.cacheResponse(r, cacheRecorder, ttl, tags)
+		}
+	}
 }
 
 func (h Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	if strings.ToLower(r.Header.Get("Upgrade")) == "websocket" {
 		conn, err := h.Upgrade(w, r, nil)
 		if err == nil {
-			h.handleWS(r.Context(), conn)
+			h.handleWS(r.Context(), conn, r.RemoteAddr)
 		}
 	} else {
 		h.serve(w, r, nil, nil, nil)
@@ -0,0 +1,200 @@
+package wok
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const defaultIdempotencyTTL = 24 * time.Hour
+
+// CachedResponse holds a rendered response
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// IdempotencyStore keeps the responses of requests carrying an
+// idempotency key, so they can be replayed when the key is repeated
+type IdempotencyStore interface {
+	// Claim reserves the given key, returning the stored response if there's
+	// one, or false if the key is already reserved by an ongoing request
+	Claim(key string, ttl time.Duration) (response *CachedResponse, claimed bool)
+
+	// Set stores the response of a previously claimed key
+	Set(key string, response *CachedResponse, ttl time.Duration)
+
+	// Release drops the reservation of a key without storing a response
+	Release(key string)
+}
+
+type idempotencyEntry struct {
+	response *CachedResponse
+	expires  time.Time
+}
+
+// MemoryIdempotencyStore implements an in-memory idempotency store
+type MemoryIdempotencyStore struct {
+	mutex   sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+// NewMemoryIdempotencyStore builds a new in-memory idempotency store
+func NewMemoryIdempotencyStore() *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{
+		entries: make(map[string]*idempotencyEntry),
+	}
+}
+
+func (s *MemoryIdempotencyStore) sweep(now time.Time) {
+	for key, entry := range s.entries {
+		if now.After(entry.expires) {
+			delete(s.entries, key)
+		}
+	}
+}
+
+// Claim reserves the given key, returning the stored response if there's
+// one, or false if the key is already reserved by an ongoing request
+func (s *MemoryIdempotencyStore) Claim(key string, ttl time.Duration) (*CachedResponse, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	if entry, ok := s.entries[key]; ok {
+		return entry.response, false
+	}
+
+	s.entries[key] = &idempotencyEntry{expires: now.Add(ttl)}
+	return nil, true
+}
+
+// Set stores the response of a previously claimed key
+func (s *MemoryIdempotencyStore) Set(key string, response *CachedResponse, ttl time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.entries[key] = &idempotencyEntry{
+		response: response,
+		expires:  time.Now().Add(ttl),
+	}
+}
+
+// Release drops the reservation of a key without storing a response
+func (s *MemoryIdempotencyStore) Release(key string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if entry, ok := s.entries[key]; ok && entry.response == nil {
+		delete(s.entries, key)
+	}
+}
+
+// responseRecorder copies everything written to a response
+type responseRecorder struct {
+	http.ResponseWriter
+	statusCode int
+	header     http.Header
+	body       bytes.Buffer
+}
+
+func (w *responseRecorder) WriteHeader(statusCode int) {
+	if w.statusCode == 0 {
+		w.statusCode = statusCode
+		w.header = w.ResponseWriter.Header().Clone()
+	}
+
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.statusCode == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	w.body.Write(p)
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *responseRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *responseRecorder) response() *CachedResponse {
+	statusCode := w.statusCode
+	header := w.header
+	if statusCode == 0 {
+		statusCode = http.StatusOK
+		header = w.ResponseWriter.Header().Clone()
+	}
+
+	return &CachedResponse{
+		StatusCode: statusCode,
+		Header:     header,
+		Body:       w.body.Bytes(),
+	}
+}
+
+func copyHeader(dst http.Header, src http.Header) {
+	for key, values := range src {
+		dst[key] = append([]string{}, values...)
+	}
+}
+
+func (response *CachedResponse) replay(w http.ResponseWriter) {
+	copyHeader(w.Header(), response.Header)
+	w.WriteHeader(response.StatusCode)
+	w.Write(response.Body)
+}
+
+// idempotencyScope identifies the caller of the given request, by default
+// using its instance ID
+func (h Handler) idempotencyScope(r *http.Request) string {
+	if h.IdempotencyScope != nil {
+		return h.IdempotencyScope(r)
+	}
+
+	instanceIDHeader := h.InstanceIDHeader
+	if instanceIDHeader == "" {
+		instanceIDHeader = "X-Wok-Instance-ID"
+	}
+
+	return r.Header.Get(instanceIDHeader)
+}
+
+// idempotencyKey builds the store key of a mutating request, scoped to its
+// caller, or an empty string if it shouldn't be deduplicated
+func (h Handler) idempotencyKey(r *http.Request) string {
+	if h.IdempotencyStore == nil {
+		return ""
+	}
+
+	header := h.IdempotencyHeader
+	if header == "" {
+		header = "Idempotency-Key"
+	}
+
+	key := r.Header.Get(header)
+	if key == "" {
+		return ""
+	}
+
+	call := r.Header.Get("X-Wok-Call")
+	if call == "" && (r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions) {
+		return ""
+	}
+
+	scope := h.idempotencyScope(r)
+	if scope == "" {
+		return ""
+	}
+
+	return strconv.Quote(scope) + " " + r.Method + " " + r.URL.RequestURI() + " " + call + " " + key
+}
@@ -0,0 +1,38 @@
+package wok
+
+import (
+	"testing"
+
+	"github.com/manvalls/wit"
+)
+
+func TestIdempotencyReplays(t *testing.T) {
+	runs := 0
+	h := single(func() Controller {
+		return NewTree(Run(func(r Request) wit.Command {
+			runs++
+			return text("CREATED")
+		}), nil)
+	})
+
+	h.IdempotencyStore = NewMemoryIdempotencyStore()
+
+	first := serve(h, "POST", "/", "X-Wok-Instance-ID", "client", "Idempotency-Key", "k1")
+	second := serve(h, "POST", "/", "X-Wok-Instance-ID", "client", "Idempotency-Key", "k1")
+	expectStatus(t, second, 200)
+
+	if runs != 1 {
+		t.Errorf("expected a single run, got %d", runs)
+	}
+
+	if second.Header().Get("Idempotent-Replayed") != "true" || second.Body.String() != first.Body.String() {
+		t.Errorf("unexpected replay %v: %s", second.Header(), second.Body.String())
+	}
+
+	serve(h, "POST", "/", "X-Wok-Instance-ID", "other", "Idempotency-Key", "k1")
+	serve(h, "POST", "/", "Idempotency-Key", "k1")
+	serve(h, "POST", "/", "Idempotency-Key", "k1")
+	if runs != 4 {
+		t.Errorf("keys were shared across clients, got %d runs", runs)
+	}
+}
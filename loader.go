@@ -0,0 +1,245 @@
+package wok
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BatchFunc fetches the values of several keys at once, keys missing
+// from the returned map are considered to have a nil value
+type BatchFunc func(ctx context.Context, keys []string) (map[string]interface{}, error)
+
+// Loader describes a keyed fetcher whose fetches are deduped, batched and
+// cached across every plan of a request, including internal redirections
+type Loader struct {
+	Batch BatchFunc
+
+	// Wait is the time spent collecting keys before fetching them,
+	// a millisecond by default
+	Wait time.Duration
+
+	// MaxBatch limits the number of keys fetched at once, if positive
+	MaxBatch int
+}
+
+// NewLoader builds a new loader around the provided batch function
+func NewLoader(batch BatchFunc) *Loader {
+	return &Loader{Batch: batch}
+}
+
+const defaultLoaderWait = time.Millisecond
+
+type loaderEntry struct {
+	done  chan struct{}
+	value interface{}
+	err   error
+}
+
+type loaderBatch struct {
+	keys    []string
+	entries []*loaderEntry
+}
+
+type loaderState struct {
+	mutex   sync.Mutex
+	loader  *Loader
+	cache   map[string]*loaderEntry
+	pending *loaderBatch
+}
+
+type loaders struct {
+	ctx    context.Context
+	mutex  sync.Mutex
+	states map[*Loader]*loaderState
+}
+
+func newLoaders(ctx context.Context) *loaders {
+	return &loaders{
+		ctx:    ctx,
+		states: make(map[*Loader]*loaderState),
+	}
+}
+
+func (l *loaders) state(loader *Loader) *loaderState {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	state := l.states[loader]
+	if state == nil {
+		state = &loaderState{
+			loader: loader,
+			cache:  make(map[string]*loaderEntry),
+		}
+
+		l.states[loader] = state
+	}
+
+	return state
+}
+
+func (l *loaders) entry(loader *Loader, key string) *loaderEntry {
+	state := l.state(loader)
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	entry := state.cache[key]
+	if entry != nil {
+		return entry
+	}
+
+	entry = &loaderEntry{done: make(chan struct{})}
+	state.cache[key] = entry
+
+	batch := state.pending
+	if batch == nil {
+		batch = &loaderBatch{}
+		state.pending = batch
+
+		wait := loader.Wait
+		if wait <= 0 {
+			wait = defaultLoaderWait
+		}
+
+		time.AfterFunc(wait, func() {
+			l.dispatch(state, batch)
+		})
+	}
+
+	batch.keys = append(batch.keys, key)
+	batch.entries = append(batch.entries, entry)
+
+	if loader.MaxBatch > 0 && len(batch.keys) >= loader.MaxBatch {
+		state.pending = nil
+		go l.fetch(state, batch)
+	}
+
+	return entry
+}
+
+func (l *loaders) dispatch(state *loaderState, batch *loaderBatch) {
+	state.mutex.Lock()
+	if state.pending != batch {
+		state.mutex.Unlock()
+		return
+	}
+
+	state.pending = nil
+	state.mutex.Unlock()
+
+	l.fetch(state, batch)
+}
+
+func (l *loaders) fetch(state *loaderState, batch *loaderBatch) {
+	values, err := l.batch(state.loader, batch.keys)
+
+	if err != nil {
+		// Failed keys are forgotten so that they can be fetched again
+		state.mutex.Lock()
+		for i, key := range batch.keys {
+			if state.cache[key] == batch.entries[i] {
+				delete(state.cache, key)
+			}
+		}
+		state.mutex.Unlock()
+	}
+
+	for i, key := range batch.keys {
+		entry := batch.entries[i]
+		entry.value = values[key]
+		entry.err = err
+		close(entry.done)
+	}
+}
+
+// batch runs the batch function of the loader, reporting its panics as
+// errors since they happen outside of the fetching plans
+func (l *loaders) batch(loader *Loader, keys []string) (values map[string]interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			values, err = nil, fmt.Errorf("wok: loader panic: %v", p)
+		}
+	}()
+
+	return loader.Batch(l.ctx, keys)
+}
+
+// fetchers returns the loaders of the request along with the context
+// bounding fetches, requests not built by a handler share nothing
+func (r ReadOnlyRequest) fetchers() (*loaders, context.Context) {
+	ctx := r.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if r.loaders == nil {
+		return newLoaders(ctx), ctx
+	}
+
+	return r.loaders, ctx
+}
+
+// Fetch retrieves the value of the given key using the provided loader
+func (r ReadOnlyRequest) Fetch(loader *Loader, key string) (interface{}, error) {
+	loaders, ctx := r.fetchers()
+	entry := loaders.entry(loader, key)
+
+	select {
+	case <-entry.done:
+		return entry.value, entry.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// FetchAll retrieves the values of the given keys using the provided loader
+func (r ReadOnlyRequest) FetchAll(loader *Loader, keys ...string) ([]interface{}, error) {
+	loaders, ctx := r.fetchers()
+	entries := make([]*loaderEntry, len(keys))
+	for i, key := range keys {
+		entries[i] = loaders.entry(loader, key)
+	}
+
+	values := make([]interface{}, len(keys))
+	for i, entry := range entries {
+		select {
+		case <-entry.done:
+			if entry.err != nil {
+				return nil, entry.err
+			}
+
+			values[i] = entry.value
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return values, nil
+}
+
+// Prime stores the value of the given key in the provided loader's cache,
+// unless it's already there
+func (r ReadOnlyRequest) Prime(loader *Loader, key string, value interface{}) {
+	if r.loaders == nil {
+		return
+	}
+
+	state := r.loaders.state(loader)
+
+	state.mutex.Lock()
+	defer state.mutex.Unlock()
+
+	if state.cache[key] != nil {
+		return
+	}
+
+	entry := &loaderEntry{
+		done:  make(chan struct{}),
+		value: value,
+	}
+
+	close(entry.done)
+	state.cache[key] = entry
+}
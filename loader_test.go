@@ -0,0 +1,86 @@
+package wok
+
+import (
+	"context"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLoaderBatchesAndCaches(t *testing.T) {
+	var calls int32
+	loader := NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+
+		values := make(map[string]interface{})
+		for _, key := range keys {
+			values[key] = strings.ToUpper(key)
+		}
+
+		return values, nil
+	})
+
+	request := ReadOnlyRequest{
+		Context: context.Background(),
+		loaders: newLoaders(context.Background()),
+	}
+
+	values, err := request.FetchAll(loader, "a", "b")
+	if err != nil || values[0] != "A" || values[1] != "B" {
+		t.Fatalf("unexpected result %v, %v", values, err)
+	}
+
+	value, err := request.Fetch(loader, "a")
+	if err != nil || value != "A" {
+		t.Fatalf("unexpected result %v, %v", value, err)
+	}
+
+	if calls != 1 {
+		t.Errorf("expected a single batch, got %d", calls)
+	}
+}
+
+func TestLoaderRecoversBatchPanics(t *testing.T) {
+	loader := NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+		panic("boom")
+	})
+
+	request := ReadOnlyRequest{
+		Context: context.Background(),
+		loaders: newLoaders(context.Background()),
+	}
+
+	done := make(chan error, 2)
+	for _, key := range []string{"a", "b"} {
+		go func(key string) {
+			_, err := request.Fetch(loader, key)
+			done <- err
+		}(key)
+	}
+
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err == nil || !strings.Contains(err.Error(), "boom") {
+				t.Errorf("expected the panic as an error, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("pending fetches were never released")
+		}
+	}
+}
+
+func TestLoaderWithoutHandler(t *testing.T) {
+	loader := NewLoader(func(ctx context.Context, keys []string) (map[string]interface{}, error) {
+		return map[string]interface{}{"a": 1}, nil
+	})
+
+	var request ReadOnlyRequest
+	request.Prime(loader, "a", 2)
+
+	value, err := request.Fetch(loader, "a")
+	if err != nil || value != 1 {
+		t.Errorf("unexpected result %v, %v", value, err)
+	}
+}
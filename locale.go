@@ -0,0 +1,141 @@
+package wok
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/manvalls/way"
+)
+
+// Catalog maps locales to the messages translated to them, indexed by key
+type Catalog map[string]map[string]string
+
+// LocalizedRoutes prefixes the paths of the given route maps with a route
+// parameter holding the locale, e.g. /about becoming /:locale/about
+func LocalizedRoutes(param string, maps ...way.RouteMap) way.RouteMap {
+	result := way.RouteMap{}
+	for _, m := range maps {
+		for path, route := range m {
+			result["/:"+param+strings.TrimSuffix(path, "/")] = way.Clone(route)
+		}
+	}
+
+	return result
+}
+
+func baseLanguage(locale string) string {
+	return strings.SplitN(locale, "-", 2)[0]
+}
+
+// matchLocale looks for the supported locale matching the given one,
+// either exactly or by its base language
+func matchLocale(locale string, locales []string) string {
+	for _, supported := range locales {
+		if strings.EqualFold(supported, locale) {
+			return supported
+		}
+	}
+
+	base := baseLanguage(locale)
+	for _, supported := range locales {
+		if strings.EqualFold(baseLanguage(supported), base) {
+			return supported
+		}
+	}
+
+	return ""
+}
+
+type acceptedLanguage struct {
+	tag     string
+	quality float64
+}
+
+// acceptLanguage negotiates the locale using the Accept-Language header
+func acceptLanguage(header string, locales []string) string {
+	accepted := []acceptedLanguage{}
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		language := acceptedLanguage{tag: strings.TrimSpace(fields[0]), quality: 1}
+		if language.tag == "" || language.tag == "*" {
+			continue
+		}
+
+		for _, field := range fields[1:] {
+			field = strings.TrimSpace(field)
+			if strings.HasPrefix(field, "q=") {
+				if q, err := strconv.ParseFloat(field[2:], 64); err == nil {
+					language.quality = q
+				}
+			}
+		}
+
+		if language.quality > 0 {
+			accepted = append(accepted, language)
+		}
+	}
+
+	sort.SliceStable(accepted, func(i, j int) bool {
+		return accepted[i].quality > accepted[j].quality
+	})
+
+	for _, language := range accepted {
+		if locale := matchLocale(language.tag, locales); locale != "" {
+			return locale
+		}
+	}
+
+	return ""
+}
+
+// negotiateLocale picks the locale of the request out of the supported ones,
+// using the route parameter, the cookie or the Accept-Language header, in
+// that order, and returning the headers the choice varies on
+func (h Handler) negotiateLocale(r *http.Request, params Params) (string, []string) {
+	if h.LocaleParam != "" && len(params[h.LocaleParam]) > 0 {
+		if locale := matchLocale(params[h.LocaleParam][0], h.Locales); locale != "" {
+			return locale, nil
+		}
+	}
+
+	vary := []string{}
+
+	if h.LocaleCookie != "" {
+		vary = append(vary, "Cookie")
+		if cookie, err := r.Cookie(h.LocaleCookie); err == nil {
+			if locale := matchLocale(cookie.Value, h.Locales); locale != "" {
+				return locale, vary
+			}
+		}
+	}
+
+	vary = append(vary, "Accept-Language")
+	if locale := acceptLanguage(r.Header.Get("Accept-Language"), h.Locales); locale != "" {
+		return locale, vary
+	}
+
+	return h.Locales[0], vary
+}
+
+// T translates the message with the given key to the locale of the request,
+// falling back to its base language, the default locale and the key itself.
+// Arguments, if any, are applied to the message using fmt.Sprintf.
+func (r ReadOnlyRequest) T(key string, args ...interface{}) string {
+	message := key
+
+	for _, locale := range []string{r.Locale, baseLanguage(r.Locale), r.defaultLocale} {
+		if translated, ok := r.catalog[locale][key]; ok {
+			message = translated
+			break
+		}
+	}
+
+	if len(args) == 0 {
+		return message
+	}
+
+	return fmt.Sprintf(message, args...)
+}
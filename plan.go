@@ -2,6 +2,7 @@ package wok
 
 import (
 	"net/http"
+	"time"
 
 	"github.com/manvalls/wit"
 )
@@ -16,6 +17,7 @@ type plan struct {
 	doFn    func(r ReadOnlyRequest)
 	command wit.Command
 	deps    func(string) wit.Command
+	guard   func(r Request) error
 	Options
 }
 
@@ -44,15 +46,32 @@ const (
 type Options struct {
 	sync        bool
 	exclusive   bool
+	name        string
+	needs       []string
+	stream      bool
+	timeout     time.Duration
+	fallback    wit.Command
+	suspense    time.Duration
+	placeholder wit.Command
 	handler     bool
 	navigation  bool
 	ajax        bool
 	socket      int
 	params      []string
+	allParams   bool
+	binder      *binder
+	correct     bool
+	onInvalid   func(r Request, errs ParamErrors) wit.Command
+	services    Services
 	methods     map[string]bool
 	exclMethods map[string]bool
 	calls       map[string]bool
 	exclCalls   map[string]bool
+	limiter     Limiter
+	limitBy     []RateDimension
+	cacheTTL    time.Duration
+	cacheTags   []string
+	planCache   *planCacheOptions
 	*linkedPlan
 }
 
@@ -209,12 +228,66 @@ func (o Options) Tap(fn func(r ReadOnlyRequest)) Options {
 	return r
 }
 
+// Provide makes the given values available through Request.Service, indexed
+// by their dynamic type, to the plans of this controller and its children
+func (o Options) Provide(values ...interface{}) Options {
+	return o.addServices(Services{}.Provide(values...))
+}
+
+// ProvideAs makes the given value available through Request.Service, indexed
+// by the type pointed to by ptr, to the plans of this controller and its children
+func (o Options) ProvideAs(ptr interface{}, value interface{}) Options {
+	return o.addServices(Services{}.ProvideAs(ptr, value))
+}
+
+func (o Options) addServices(services Services) Options {
+	o.linkedPlan = &linkedPlan{
+		parent: o.linkedPlan,
+		plan: Procedure{
+			plans: []plan{
+				{
+					Options: Options{services: services},
+				},
+			},
+		},
+	}
+
+	return o
+}
+
 // Sync runs plans sequentially
 func (o Options) Sync() Options {
 	o.sync = true
 	return o
 }
 
+// Stream allows the response to be sent before plans finish, delivering
+// their commands later on through the socket, or as patches when the
+// response format supports them
+func (o Options) Stream() Options {
+	o.stream = true
+	return o
+}
+
+// Timeout cancels the context of plans running for longer than the given
+// duration, applying the provided fallback commands instead
+func (o Options) Timeout(d time.Duration, fallback ...wit.Command) Options {
+	o.timeout = d
+	o.fallback = wit.List(fallback...)
+	return o
+}
+
+// Suspense applies the provided placeholder commands when non-sync plans
+// take longer than the given duration, delivering their commands later on
+// through the socket, or as patches of HTML responses and other formats
+// supporting them. Plans aren't suspended within responses whose format
+// doesn't support patches, such as JSON ones, which wait for them instead.
+func (o Options) Suspense(d time.Duration, placeholder ...wit.Command) Options {
+	o.suspense = d
+	o.placeholder = wit.List(placeholder...)
+	return o
+}
+
 // Excl runs plans exclusively, no other plan is allowed
 // to run at the same time
 func (o Options) Excl() Options {
@@ -222,23 +295,95 @@ func (o Options) Excl() Options {
 	return o
 }
 
+// As names the derived plans, allowing other plans at the same level or
+// below to use the results they publish with Request.SetResult
+func (o Options) As(name string) Options {
+	if o.planCache != nil {
+		panic("wok: cached plans can't be named")
+	}
+
+	o.name = name
+	return o
+}
+
+// Needs runs the derived plans once the nearest plans with the given names,
+// at the same level or above, finish, making their results available
+// through Request.Result. Needed plans run even when their commands don't
+// need to be applied again, which are then discarded.
+func (o Options) Needs(names ...string) Options {
+	needs := make([]string, len(o.needs), len(o.needs)+len(names))
+	copy(needs, o.needs)
+
+	o.needs = append(needs, names...)
+	return o
+}
+
 // Always runs plans even if it wouldn't be necessary
 func (o Options) Always() Options {
 	o.handler = true
 	return o
 }
 
-// With makes the given list of parameters available to the derived plans
+// With makes the given list of parameters available to the derived plans,
+// which will only be run again when one of them changes
 func (o Options) With(params ...string) Options {
 	newParams := make([]string, len(o.params), len(o.params)+len(params))
-	if len(newParams) > 0 {
-		copy(newParams, o.params)
-	}
+	copy(newParams, o.params)
+
+	o.params = append(newParams, params...)
+	return o
+}
+
+// WithAll makes every parameter available to the derived plans,
+// which will be run again when any of them changes
+func (o Options) WithAll() Options {
+	o.allParams = true
+	return o
+}
+
+// Bind parses the parameters of the derived plans into a new instance of the
+// provided struct, available as Request.Bound, making them available as With
+// does. Fields are described by tags of the form:
+//
+//	`param:"name,required,default=1,min=1,max=10,enum=1|2|5|10"`
+//
+// Plans aren't run when parameters fail to bind, setting a 400 status code.
+func (o Options) Bind(prototype interface{}) Options {
+	o.binder = newBinder(prototype)
+	return o.With(o.binder.names()...)
+}
+
+// OnInvalidParams applies the command returned by the provided function
+// instead of the derived plans when their parameters fail to bind
+func (o Options) OnInvalidParams(fn func(r Request, errs ParamErrors) wit.Command) Options {
+	o.onInvalid = fn
+	return o
+}
 
-	o.params = append(o.params, params...)
+// CorrectParams issues an internal redirection to corrected parameters when
+// the ones of the derived plans fail to bind, replacing invalid values with
+// their defaults or limits
+func (o Options) CorrectParams() Options {
+	o.correct = true
 	return o
 }
 
+func (o Options) pickParams(params Params) Params {
+	if o.allParams {
+		return cloneParams(params)
+	}
+
+	return pickParams(params, o.params)
+}
+
+func (o Options) paramsChanged(oldParams Params, newParams Params) bool {
+	if o.allParams {
+		return paramsChanged(oldParams, newParams, allParams(oldParams, newParams))
+	}
+
+	return paramsChanged(oldParams, newParams, o.params)
+}
+
 // Navigation runs plans on navigation
 func (o Options) Navigation() Options {
 	o.navigation = true
@@ -358,6 +503,12 @@ func (o Options) NoDelete() Options {
 	return o.NoMethod(http.MethodDelete)
 }
 
+// NoHead is an alias for NoMethod("HEAD"), skipping plans which
+// only contribute to the body of the response
+func (o Options) NoHead() Options {
+	return o.NoMethod(http.MethodHead)
+}
+
 // Call runs this plan when the request matches one of the provided calls
 func (o Options) Call(calls ...string) Options {
 	o.calls = copyMethodMap(o.calls)
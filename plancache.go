@@ -0,0 +1,81 @@
+package wok
+
+import (
+	"time"
+
+	"github.com/manvalls/wit"
+)
+
+// PlanCache stores the commands returned by plans
+type PlanCache interface {
+	Get(key string) (wit.Command, bool)
+	Set(key string, command wit.Command, ttl time.Duration, tags []string)
+
+	// Invalidate removes every command stored with any of the given tags
+	Invalidate(tags ...string)
+}
+
+// MemoryPlanCache implements an in-memory plan cache
+type MemoryPlanCache struct {
+	memoryCache
+}
+
+// NewMemoryPlanCache builds a new in-memory plan cache
+func NewMemoryPlanCache() *MemoryPlanCache {
+	return &MemoryPlanCache{newMemoryCache()}
+}
+
+// Get retrieves the command stored with the given key, if it didn't expire
+func (c *MemoryPlanCache) Get(key string) (wit.Command, bool) {
+	value, ok := c.get(key)
+	if !ok {
+		return nil, false
+	}
+
+	command, _ := value.(wit.Command)
+	return command, true
+}
+
+// Set stores a command with the given key
+func (c *MemoryPlanCache) Set(key string, command wit.Command, ttl time.Duration, tags []string) {
+	c.set(key, command, ttl, tags)
+}
+
+// DefaultPlanCache is used by plans when the handler doesn't provide a cache
+var DefaultPlanCache PlanCache = NewMemoryPlanCache()
+
+type planCacheOptions struct {
+	key  func(r Request) string
+	ttl  time.Duration
+	tags []string
+}
+
+// Cache memoizes the commands returned by plans across requests, indexed by
+// the key returned by the provided function, which should identify both the
+// plan and the data it depends on. Socket requests always run the plans.
+// Only commands are stored: when found, the plans don't run, so they shouldn't
+// have any other effect, such as setting headers, validators, status codes or
+// results, loading dependencies or redirecting. Cached plans can't be named.
+func (o Options) Cache(key func(r Request) string, ttl time.Duration, tags ...string) Options {
+	if o.name != "" {
+		panic("wok: named plans can't be cached")
+	}
+
+	o.planCache = &planCacheOptions{key, ttl, append([]string{}, tags...)}
+	return o
+}
+
+// cached wraps the given plan function so its commands are
+// looked up in and stored to the provided cache
+func (c *planCacheOptions) cached(cache PlanCache, fn func(r Request) wit.Command) func(r Request) wit.Command {
+	return func(r Request) wit.Command {
+		key := c.key(r)
+		if command, ok := cache.Get(key); ok {
+			return command
+		}
+
+		command := fn(r)
+		cache.Set(key, command, c.ttl, c.tags)
+		return command
+	}
+}
@@ -0,0 +1,57 @@
+package wok
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manvalls/wit"
+)
+
+func TestPlanCache(t *testing.T) {
+	runs := 0
+	h := single(func() Controller {
+		key := func(r Request) string {
+			return "items:" + r.URL.Query().Get("page")
+		}
+
+		return NewTree(Cache(key, time.Minute, "items").Run(func(r Request) wit.Command {
+			runs++
+			return text("ITEMS")
+		}), nil)
+	})
+
+	cache := NewMemoryPlanCache()
+	h.PlanCache = cache
+
+	for _, target := range []string{"/?page=1", "/?page=1", "/?page=2"} {
+		body := serve(h, "GET", target).Body.String()
+		if !strings.Contains(body, "ITEMS") {
+			t.Fatalf("unexpected response %s", body)
+		}
+	}
+
+	if runs != 2 {
+		t.Errorf("expected a run per key, got %d", runs)
+	}
+
+	cache.Invalidate("items")
+	serve(h, "GET", "/?page=1")
+	if runs != 3 {
+		t.Errorf("invalidated commands were applied")
+	}
+}
+
+func TestPlanCacheRejectsNamedPlans(t *testing.T) {
+	key := func(r Request) string {
+		return ""
+	}
+
+	expectPanic(t, func() {
+		As("items").Cache(key, time.Minute)
+	})
+
+	expectPanic(t, func() {
+		Cache(key, time.Minute).As("items")
+	})
+}
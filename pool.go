@@ -0,0 +1,178 @@
+package wok
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// QueueStrategy decides what happens to plans when a pool is full
+type QueueStrategy int
+
+const (
+	// QueueFIFO makes plans wait for a free slot, in arrival order
+	QueueFIFO QueueStrategy = iota
+
+	// QueueLIFO makes plans wait for a free slot, most recent plans first
+	QueueLIFO
+
+	// CallerRuns runs plans sequentially when the pool is full,
+	// without spawning new goroutines
+	CallerRuns
+)
+
+// PoolStats holds a snapshot of the metrics of a pool
+type PoolStats struct {
+	Running   int
+	Waiting   int
+	Acquired  uint64
+	Inlined   uint64
+	TotalWait time.Duration
+	MaxWait   time.Duration
+}
+
+// Pool limits the number of non-sync plans running at the same time
+type Pool struct {
+	size     int
+	strategy QueueStrategy
+
+	// OnWait, when provided, is called with the time spent by each plan
+	// waiting for a free slot
+	OnWait func(time.Duration)
+
+	mutex   sync.Mutex
+	waiters []chan struct{}
+	stats   PoolStats
+}
+
+// NewPool builds a new pool allowing up to size plans to run concurrently,
+// size being required to be positive
+func NewPool(size int, strategy QueueStrategy) *Pool {
+	if size <= 0 {
+		panic("wok: invalid pool size " + strconv.Itoa(size))
+	}
+
+	return &Pool{
+		size:     size,
+		strategy: strategy,
+	}
+}
+
+// Stats returns the current metrics of this pool
+func (p *Pool) Stats() PoolStats {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	stats := p.stats
+	stats.Waiting = len(p.waiters)
+	return stats
+}
+
+// acquire reserves a slot in the pool, returning false if the plan
+// should be run by the caller instead
+func (p *Pool) acquire(ctx context.Context) (bool, error) {
+	p.mutex.Lock()
+
+	if p.stats.Running < p.size {
+		p.stats.Running++
+		p.stats.Acquired++
+		p.mutex.Unlock()
+		p.waited(0)
+		return true, nil
+	}
+
+	if p.strategy == CallerRuns {
+		p.stats.Inlined++
+		p.mutex.Unlock()
+		return false, nil
+	}
+
+	start := time.Now()
+	ch := make(chan struct{})
+	p.waiters = append(p.waiters, ch)
+	p.mutex.Unlock()
+
+	select {
+	case <-ch:
+		p.waited(time.Since(start))
+		return true, nil
+	case <-ctx.Done():
+		p.mutex.Lock()
+		for i, waiter := range p.waiters {
+			if waiter == ch {
+				p.waiters = append(p.waiters[:i], p.waiters[i+1:]...)
+				p.mutex.Unlock()
+				return false, ctx.Err()
+			}
+		}
+
+		p.mutex.Unlock()
+
+		// The slot was handed to us while the context was being cancelled
+		p.release()
+		return false, ctx.Err()
+	}
+}
+
+func (p *Pool) waited(d time.Duration) {
+	p.mutex.Lock()
+	p.stats.TotalWait += d
+	if d > p.stats.MaxWait {
+		p.stats.MaxWait = d
+	}
+	p.mutex.Unlock()
+
+	if p.OnWait != nil {
+		p.OnWait(d)
+	}
+}
+
+func (p *Pool) release() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if len(p.waiters) == 0 {
+		p.stats.Running--
+		return
+	}
+
+	var ch chan struct{}
+	if p.strategy == QueueLIFO {
+		ch = p.waiters[len(p.waiters)-1]
+		p.waiters = p.waiters[:len(p.waiters)-1]
+	} else {
+		ch = p.waiters[0]
+		p.waiters = p.waiters[1:]
+	}
+
+	p.stats.Acquired++
+	close(ch)
+}
+
+// acquirePools reserves a slot in every provided pool, returning the
+// function which releases them, or nil if the plan should be run by the caller
+func acquirePools(ctx context.Context, pools []*Pool) (func(), error) {
+	acquired := make([]*Pool, 0, len(pools))
+	release := func() {
+		for _, pool := range acquired {
+			pool.release()
+		}
+	}
+
+	for _, pool := range pools {
+		if pool == nil {
+			continue
+		}
+
+		ok, err := pool.acquire(ctx)
+		if err != nil || !ok {
+			release()
+			return nil, err
+		}
+
+		acquired = append(acquired, pool)
+	}
+
+	return release, nil
+}
@@ -0,0 +1,100 @@
+package wok
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/manvalls/wit"
+)
+
+func TestPoolBoundsConcurrency(t *testing.T) {
+	mutex := sync.Mutex{}
+	running, max := 0, 0
+
+	slow := Run(func(r Request) wit.Command {
+		mutex.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mutex.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		mutex.Lock()
+		running--
+		mutex.Unlock()
+		return nil
+	})
+
+	h := single(func() Controller {
+		return NewTree(List(slow, slow, slow, slow), nil)
+	})
+
+	h.Pool = NewPool(2, QueueFIFO)
+	expectStatus(t, serve(h, "GET", "/"), 200)
+
+	if max != 2 {
+		t.Errorf("expected 2 plans running at most, got %d", max)
+	}
+
+	if stats := h.Pool.Stats(); stats.Running != 0 || stats.Acquired != 4 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+}
+
+func TestPoolWaitIsBoundedByTimeout(t *testing.T) {
+	slow := Timeout(20*time.Millisecond, text("FALLBACK")).Run(func(r Request) wit.Command {
+		time.Sleep(200 * time.Millisecond)
+		return text("SLOW")
+	})
+
+	h := single(func() Controller {
+		return NewTree(List(slow, slow), nil)
+	})
+
+	h.Pool = NewPool(1, QueueFIFO)
+
+	start := time.Now()
+	body := serve(h, "GET", "/", "Accept", "application/json").Body.String()
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("response took %v", elapsed)
+	}
+
+	if strings.Count(body, "FALLBACK") != 2 || strings.Contains(body, "SLOW") {
+		t.Errorf("expected both plans to fall back: %s", body)
+	}
+}
+
+func TestPoolCallerRuns(t *testing.T) {
+	plan := Run(func(r Request) wit.Command {
+		time.Sleep(5 * time.Millisecond)
+		return text("DONE")
+	})
+
+	h := single(func() Controller {
+		return NewTree(List(plan, plan, plan), nil)
+	})
+
+	h.Pool = NewPool(1, CallerRuns)
+	body := serve(h, "GET", "/", "Accept", "application/json").Body.String()
+	if strings.Count(body, "DONE") != 3 {
+		t.Errorf("expected every plan to run: %s", body)
+	}
+
+	if stats := h.Pool.Stats(); stats.Inlined == 0 {
+		t.Errorf("expected some plans to be inlined: %+v", stats)
+	}
+}
+
+func TestNewPoolRejectsInvalidSizes(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected NewPool to panic")
+		}
+	}()
+
+	NewPool(0, QueueFIFO)
+}
@@ -0,0 +1,222 @@
+package wok
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is wrapped by the failure of rate limited requests
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// Limiter decides whether the action identified by the given key may
+// happen now, returning how long to wait otherwise
+type Limiter interface {
+	Allow(key string) (allowed bool, retryAfter time.Duration)
+}
+
+// RateDimension extracts a part of the key requests are limited by
+type RateDimension func(r ReadOnlyRequest) string
+
+// ByInstance limits requests per client instance
+func ByInstance(r ReadOnlyRequest) string {
+	return r.InstanceID
+}
+
+// ByIP limits requests per remote IP
+func ByIP(r ReadOnlyRequest) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// ByRoute limits requests per requested path
+func ByRoute(r ReadOnlyRequest) string {
+	return r.URL.Path
+}
+
+// ByCall limits requests per call name
+func ByCall(r ReadOnlyRequest) string {
+	return r.Call.Name
+}
+
+// rateKey joins the given dimensions, limiting by instance if there are none
+// so that clients never share a single key
+func rateKey(r ReadOnlyRequest, dimensions []RateDimension) string {
+	if len(dimensions) == 0 {
+		dimensions = []RateDimension{ByInstance}
+	}
+
+	parts := make([]string, len(dimensions))
+	for i, dimension := range dimensions {
+		parts[i] = dimension(r)
+	}
+
+	return strings.Join(parts, "\x00")
+}
+
+func retryAfterSeconds(retryAfter time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(retryAfter.Seconds())))
+}
+
+type tokens struct {
+	available float64
+	last      time.Time
+}
+
+// TokenBucket implements an in-memory limiter which allows rate actions
+// per second for every key, with bursts of up to burst actions
+type TokenBucket struct {
+	rate    float64
+	burst   float64
+	mutex   sync.Mutex
+	buckets map[string]*tokens
+	swept   time.Time
+}
+
+// NewTokenBucket builds a new token bucket limiter
+func NewTokenBucket(rate float64, burst int) *TokenBucket {
+	return &TokenBucket{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokens),
+		swept:   time.Now(),
+	}
+}
+
+func (b *TokenBucket) refill(bucket *tokens, now time.Time) {
+	bucket.available += now.Sub(bucket.last).Seconds() * b.rate
+	if bucket.available > b.burst {
+		bucket.available = b.burst
+	}
+
+	bucket.last = now
+}
+
+// Allow takes a token from the bucket of the given key, if available
+func (b *TokenBucket) Allow(key string) (bool, time.Duration) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(b.swept) > time.Minute {
+		b.swept = now
+		for key, bucket := range b.buckets {
+			b.refill(bucket, now)
+			if bucket.available >= b.burst {
+				delete(b.buckets, key)
+			}
+		}
+	}
+
+	bucket := b.buckets[key]
+	if bucket == nil {
+		bucket = &tokens{available: b.burst, last: now}
+		b.buckets[key] = bucket
+	}
+
+	b.refill(bucket, now)
+	if bucket.available >= 1 {
+		bucket.available--
+		return true, 0
+	}
+
+	if b.rate <= 0 {
+		return false, time.Hour
+	}
+
+	return false, time.Duration((1 - bucket.available) / b.rate * float64(time.Second))
+}
+
+// RateLimit limits the number of times plans run, sharing the given limiter
+// among every request matching the same dimensions, ByInstance by default.
+// Requests are limited once, however many plans share the limiter. Limited
+// plans don't run, and the response gets a 429 status code and a
+// Retry-After header.
+func (o Options) RateLimit(limiter Limiter, dimensions ...RateDimension) Options {
+	o.limiter = limiter
+	o.limitBy = dimensions
+	return o
+}
+
+// rateLimitKey identifies the decisions taken during a request
+type rateLimitKey struct {
+	limiter Limiter
+	key     string
+}
+
+// rateLimit checks whether the given plan may run, updating the response
+// and returning the failure when it can't. Limiters are asked once per
+// request and key, later plans share their decision.
+func (r Request) rateLimit(info *planInfo) *Error {
+	if info.limiter == nil {
+		return nil
+	}
+
+	key := rateLimitKey{info.limiter, rateKey(r.ReadOnlyRequest, info.limitBy)}
+
+	r.limitsMutex.Lock()
+	defer r.limitsMutex.Unlock()
+
+	if failure, ok := r.limits[key]; ok {
+		return failure
+	}
+
+	allowed, retryAfter := info.limiter.Allow(key.key)
+	if allowed {
+		r.limits[key] = nil
+		return nil
+	}
+
+	failure := &Error{http.StatusTooManyRequests, ErrRateLimited}
+	r.limits[key] = failure
+
+	r.ResponseHeader.Set("Retry-After", retryAfterSeconds(retryAfter))
+	r.SetStatusCode(http.StatusTooManyRequests)
+	return failure
+}
+
+// eventLimitKey computes the key the events of a socket request are limited by
+func (h Handler) eventLimitKey(r *http.Request) string {
+	instanceIDHeader := h.InstanceIDHeader
+	if instanceIDHeader == "" {
+		instanceIDHeader = "X-Wok-Instance-ID"
+	}
+
+	request := ReadOnlyRequest{
+		Request:    r,
+		InstanceID: r.Header.Get(instanceIDHeader),
+		Call: CallData{
+			Name: strings.SplitN(r.Header.Get("X-Wok-Call"), "?", 2)[0],
+		},
+	}
+
+	return rateKey(request, h.EventLimitBy)
+}
+
+type rateLimitFrame struct {
+	Status     int    `json:"status"`
+	Error      string `json:"error"`
+	RetryAfter int    `json:"retryAfter"`
+}
+
+// rateLimitError builds the payload of the error frame sent
+// through sockets when events are rate limited
+func rateLimitError(retryAfter time.Duration) []byte {
+	frame, _ := json.Marshal(rateLimitFrame{
+		Status:     http.StatusTooManyRequests,
+		Error:      ErrRateLimited.Error(),
+		RetryAfter: int(math.Ceil(retryAfter.Seconds())),
+	})
+
+	return frame
+}
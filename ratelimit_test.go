@@ -0,0 +1,40 @@
+package wok
+
+import (
+	"testing"
+
+	"github.com/manvalls/wit"
+)
+
+func TestRateLimitByInstanceByDefault(t *testing.T) {
+	limiter := NewTokenBucket(0, 1)
+	h := single(func() Controller {
+		return NewTree(RateLimit(limiter).Run(func(r Request) wit.Command {
+			return text("ALLOWED")
+		}), nil)
+	})
+
+	expectStatus(t, serve(h, "GET", "/", "X-Wok-Instance-ID", "first"), 200)
+	expectStatus(t, serve(h, "GET", "/", "X-Wok-Instance-ID", "second"), 200)
+
+	w := serve(h, "GET", "/", "X-Wok-Instance-ID", "first")
+	expectStatus(t, w, 429)
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("missing Retry-After header")
+	}
+}
+
+func TestRateLimitOncePerRequest(t *testing.T) {
+	limiter := NewTokenBucket(0, 2)
+	h := single(func() Controller {
+		run := func(r Request) wit.Command {
+			return text("ALLOWED")
+		}
+
+		return NewTree(RateLimit(limiter).Run(run).Run(run), nil)
+	})
+
+	expectStatus(t, serve(h, "GET", "/", "X-Wok-Instance-ID", "client"), 200)
+	expectStatus(t, serve(h, "GET", "/", "X-Wok-Instance-ID", "client"), 200)
+	expectStatus(t, serve(h, "GET", "/", "X-Wok-Instance-ID", "client"), 429)
+}
@@ -0,0 +1,175 @@
+package wok
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/golang/gddo/httputil"
+	"github.com/manvalls/wit"
+)
+
+// Format describes how to render commands for a given media type
+type Format struct {
+	MediaType   string
+	Charset     string
+	NewRenderer func(command wit.Command) wit.Renderer
+
+	// NewPatchRenderer renders commands appended to an already sent response,
+	// formats without it don't support streaming
+	NewPatchRenderer func(command wit.Command) wit.Renderer
+
+	// PatchClient is applied to responses followed by patches, e.g. to set
+	// up the script which applies them
+	PatchClient wit.Command
+
+	// PatchTail is the trailing part of rendered responses which patches are
+	// inserted before, e.g. the closing tags of HTML documents
+	PatchTail string
+}
+
+// ContentType returns the value of the Content-Type header for this format
+func (f Format) ContentType() string {
+	if f.Charset != "" {
+		return f.MediaType + "; charset=" + f.Charset
+	}
+
+	return f.MediaType
+}
+
+// HTMLFormat renders commands as a full HTML document, streaming patches
+// as scripts inserted at the end of its body
+var HTMLFormat = Format{
+	MediaType:        "text/html",
+	Charset:          "utf-8",
+	NewRenderer:      wit.NewHTMLRenderer,
+	NewPatchRenderer: NewHTMLPatchRenderer,
+	PatchClient:      wit.Head.One(wit.Append(wit.FromString("<script data-w-rm>" + htmlPatchClient + "</script>"))),
+	PatchTail:        "</body></html>",
+}
+
+// JSONFormat renders commands as a JSON delta
+var JSONFormat = Format{
+	MediaType:   "application/json",
+	NewRenderer: wit.NewJSONRenderer,
+}
+
+// TextFormat renders commands as an indented JSON delta, useful for debugging
+var TextFormat = Format{
+	MediaType:   "text/plain",
+	Charset:     "utf-8",
+	NewRenderer: NewTextRenderer,
+}
+
+// DefaultFormats holds the formats used when none are provided to the handler,
+// the first one being used when no format matches the Accept header
+var DefaultFormats = []Format{HTMLFormat, JSONFormat}
+
+func negotiateFormat(r *http.Request, formats []Format) Format {
+	if len(formats) == 0 {
+		formats = DefaultFormats
+	}
+
+	offers := make([]string, len(formats))
+	for i, format := range formats {
+		offers[i] = format.MediaType
+	}
+
+	mediaType := httputil.NegotiateContentType(r, offers, offers[0])
+	for _, format := range formats {
+		if format.MediaType == mediaType {
+			return format
+		}
+	}
+
+	return formats[0]
+}
+
+type textRenderer struct {
+	command wit.Command
+}
+
+// NewTextRenderer returns a new renderer which will render a human readable
+// dump of the command tree
+func NewTextRenderer(command wit.Command) wit.Renderer {
+	return &textRenderer{command}
+}
+
+func (r *textRenderer) Render(w io.Writer) error {
+	buff := bytes.Buffer{}
+	err := wit.NewJSONRenderer(r.command).Render(&buff)
+	if err != nil {
+		return err
+	}
+
+	out := bytes.Buffer{}
+	err = json.Indent(&out, buff.Bytes(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	out.WriteByte('\n')
+	_, err = out.WriteTo(w)
+	return err
+}
+
+// htmlPatchClient defines window.SPAPatch, which applies JSON deltas
+// to the document the same way the HTML renderer does
+const htmlPatchClient = `!function(w,d){` +
+	`function f(c,h){var r=d.createRange();r.selectNodeContents(c);return r.createContextualFragment(h)}` +
+	`function a(n,t){var o=t[0],m=[],i,j,k,x,p;` +
+	`if(o==1){for(i=1;i<t.length;i++)a(n,t[i]);return}` +
+	`if(o<10){if(o==2)m.push(d);else for(j=0;j<n.length;j++){x=n[j];` +
+	`if(o==3){x=x.querySelector(t[1]);x&&m.push(x)}` +
+	`else if(o==4)m.push.apply(m,x.querySelectorAll(t[1]));` +
+	`else{x=o==5?x.parentNode:o==6?x.firstElementChild:o==7?x.lastElementChild:o==8?x.previousElementSibling:x.nextElementSibling;x&&m.push(x)}}` +
+	`for(i=o==3||o==4?2:1;i<t.length;i++)a(m,t[i]);return}` +
+	`for(j=0;j<n.length;j++){x=n[j];p=x.parentNode;` +
+	`if(o==10){p&&p.removeChild(x);continue}` +
+	`if(x.nodeType!=1)continue;` +
+	`switch(o){` +
+	`case 11:case 12:while(x.firstChild)x.removeChild(x.firstChild);o==12&&x.appendChild(f(x,t[1]));break;` +
+	`case 13:p&&(p.insertBefore(f(p,t[1]),x),p.removeChild(x));break;` +
+	`case 14:x.appendChild(f(x,t[1]));break;` +
+	`case 15:x.insertBefore(f(x,t[1]),x.firstChild);break;` +
+	`case 16:p&&p.insertBefore(f(x,t[1]),x.nextSibling);break;` +
+	`case 17:p&&p.insertBefore(f(x,t[1]),x);break;` +
+	`case 19:while(x.attributes.length)x.removeAttribute(x.attributes[0].name);` +
+	`case 18:for(k in t[1])x.setAttribute(k,t[1][k]);break;` +
+	`case 20:for(i=1;i<t.length;i++)x.removeAttribute(t[i]);break;` +
+	`case 21:for(k in t[1])x.style.setProperty(k,t[1][k]);break;` +
+	`case 22:for(i=1;i<t.length;i++)x.style.removeProperty(t[i]);break;` +
+	`case 23:case 24:k=t[1].split(/\s+/);for(i=0;i<k.length;i++)k[i]&&x.classList[o==23?'add':'remove'](k[i])` +
+	`}}}` +
+	`w.SPAPatch=w.SPP=w.SPAPatch||w.SPP||function(t){a([d],t)}` +
+	`}(window,document)`
+
+type htmlPatchRenderer struct {
+	command wit.Command
+}
+
+// NewHTMLPatchRenderer returns a new renderer which will render a script
+// applying the JSON delta of the provided command through window.SPAPatch,
+// set up by the PatchClient of HTMLFormat
+func NewHTMLPatchRenderer(command wit.Command) wit.Renderer {
+	return &htmlPatchRenderer{command}
+}
+
+func (r *htmlPatchRenderer) Render(w io.Writer) error {
+	buff := bytes.Buffer{}
+	err := wit.NewJSONRenderer(r.command).Render(&buff)
+	if err != nil {
+		return err
+	}
+
+	delta := bytes.Replace(buff.Bytes(), []byte("<"), []byte("\\u003c"), -1)
+
+	out := bytes.Buffer{}
+	out.WriteString("<script data-w-rm>SPAPatch(")
+	out.Write(delta)
+	out.WriteString(")</script>")
+
+	_, err = out.WriteTo(w)
+	return err
+}
@@ -35,7 +35,10 @@ type ReadOnlyRequest struct {
 	way.Router
 	url.Values
 	InstanceID    string
+	Locale        string
 	OldParams     url.Values
+	Bound         interface{}
+	Failure       error
 	IsNavigation  bool
 	IsSocket      bool
 	InitialLoad   bool
@@ -43,6 +46,13 @@ type ReadOnlyRequest struct {
 	Input         <-chan url.Values
 	Output        chan<- wit.Command
 	RequestHeader http.Header
+
+	loaders  *loaders
+	services *serviceScope
+	form     *form
+
+	catalog       Catalog
+	defaultLocale string
 }
 
 var errClosed = errors.New("Socket closed")
@@ -83,6 +93,9 @@ type Request struct {
 	vary      map[string]int
 	varyMutex *sync.Mutex
 
+	limits      map[rateLimitKey]*Error
+	limitsMutex *sync.Mutex
+
 	custom        *bool
 	customHandler *func(http.ResponseWriter)
 	customMutex   *sync.Mutex
@@ -92,6 +105,11 @@ type Request struct {
 	redirectCond     *sync.Cond
 	fullParams       Params
 
+	current   *planInfo
+	providers map[string]*planInfo
+
+	validators *validators
+
 	*deduper
 }
 
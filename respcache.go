@@ -0,0 +1,246 @@
+package wok
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ResponseCache stores rendered responses
+type ResponseCache interface {
+	Get(key string) (*CachedResponse, bool)
+	Set(key string, response *CachedResponse, ttl time.Duration, tags []string)
+
+	// Invalidate removes every response stored with any of the given tags
+	Invalidate(tags ...string)
+}
+
+type memoryCacheEntry struct {
+	value   interface{}
+	expires time.Time
+	tags    []string
+}
+
+// memoryCache stores values in memory, indexed by key and tags
+type memoryCache struct {
+	mutex   sync.Mutex
+	entries map[string]*memoryCacheEntry
+	tagged  map[string]map[string]bool
+	swept   time.Time
+}
+
+func newMemoryCache() memoryCache {
+	return memoryCache{
+		entries: make(map[string]*memoryCacheEntry),
+		tagged:  make(map[string]map[string]bool),
+		swept:   time.Now(),
+	}
+}
+
+func (c *memoryCache) remove(key string) {
+	entry, ok := c.entries[key]
+	if !ok {
+		return
+	}
+
+	delete(c.entries, key)
+	for _, tag := range entry.tags {
+		delete(c.tagged[tag], key)
+		if len(c.tagged[tag]) == 0 {
+			delete(c.tagged, tag)
+		}
+	}
+}
+
+func (c *memoryCache) get(key string) (interface{}, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	if time.Now().After(entry.expires) {
+		c.remove(key)
+		return nil, false
+	}
+
+	return entry.value, true
+}
+
+func (c *memoryCache) set(key string, value interface{}, ttl time.Duration, tags []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if now.Sub(c.swept) > time.Minute {
+		c.swept = now
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				c.remove(key)
+			}
+		}
+	}
+
+	c.remove(key)
+	c.entries[key] = &memoryCacheEntry{
+		value:   value,
+		expires: now.Add(ttl),
+		tags:    tags,
+	}
+
+	for _, tag := range tags {
+		if c.tagged[tag] == nil {
+			c.tagged[tag] = map[string]bool{}
+		}
+
+		c.tagged[tag][key] = true
+	}
+}
+
+// Invalidate removes every value stored with any of the given tags
+func (c *memoryCache) Invalidate(tags ...string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, tag := range tags {
+		for key := range c.tagged[tag] {
+			c.remove(key)
+		}
+	}
+}
+
+// MemoryResponseCache implements an in-memory response cache
+type MemoryResponseCache struct {
+	memoryCache
+}
+
+// NewMemoryResponseCache builds a new in-memory response cache
+func NewMemoryResponseCache() *MemoryResponseCache {
+	return &MemoryResponseCache{newMemoryCache()}
+}
+
+// Get retrieves the response stored with the given key, if it didn't expire
+func (c *MemoryResponseCache) Get(key string) (*CachedResponse, bool) {
+	value, ok := c.get(key)
+	if !ok {
+		return nil, false
+	}
+
+	return value.(*CachedResponse), true
+}
+
+// Set stores a response with the given key
+func (c *MemoryResponseCache) Set(key string, response *CachedResponse, ttl time.Duration, tags []string) {
+	c.set(key, response, ttl, tags)
+}
+
+// Cacheable allows the commands of plans to be stored by the response cache
+// of the handler for the given time. Responses are only stored when every
+// plan producing them is cacheable, using the shortest time and every tag.
+// Guards derived from cacheable options let the responses they protect be
+// stored, declaring their decision only depends on the headers these vary on.
+func (o Options) Cacheable(ttl time.Duration, tags ...string) Options {
+	o.cacheTTL = ttl
+	o.cacheTags = append([]string{}, tags...)
+	return o
+}
+
+// cacheability combines the cache options of the plans making up the
+// response, which isn't cacheable when protected by guards which didn't
+// opt in themselves
+func (v *validators) cacheability() (time.Duration, []string, bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for _, info := range v.guards {
+		if info.cacheTTL <= 0 {
+			return 0, nil, false
+		}
+	}
+
+	var ttl time.Duration
+	seen := map[string]bool{}
+	tags := []string{}
+
+	for _, info := range v.plans {
+		if info.fn == nil && info.cacheTTL <= 0 {
+			continue
+		}
+
+		if info.cacheTTL <= 0 || info.detached {
+			return 0, nil, false
+		}
+
+		if ttl == 0 || info.cacheTTL < ttl {
+			ttl = info.cacheTTL
+		}
+
+		for _, tag := range info.cacheTags {
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	return ttl, tags, ttl > 0
+}
+
+// responseCacheKey builds the base key of cached responses to the given request
+func responseCacheKey(r *http.Request) string {
+	return r.Method + " " + r.URL.RequestURI()
+}
+
+// variantKey extends the base key with the values of the varying headers
+func variantKey(key string, r *http.Request, vary []string) string {
+	for _, header := range vary {
+		key += "\n" + header + ": " + strings.Join(r.Header[http.CanonicalHeaderKey(header)], ",")
+	}
+
+	return key
+}
+
+// varyHeaders lists the headers a cached response varies on
+func varyHeaders(header http.Header) []string {
+	headers := []string{}
+	for _, value := range header["Vary"] {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				headers = append(headers, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+
+	sort.Strings(headers)
+	return headers
+}
+
+// cachedResponse looks for a stored response to the given request
+func (h Handler) cachedResponse(r *http.Request) (*CachedResponse, bool) {
+	key := responseCacheKey(r)
+
+	index, ok := h.ResponseCache.Get(key)
+	if !ok {
+		return nil, false
+	}
+
+	return h.ResponseCache.Get(variantKey(key, r, varyHeaders(index.Header)))
+}
+
+// cacheResponse stores the recorded response to the given request
+func (h Handler) cacheResponse(r *http.Request, recorder *responseRecorder, ttl time.Duration, tags []string) {
+	response := recorder.response()
+	if response.StatusCode != http.StatusOK || len(response.Header["Set-Cookie"]) > 0 {
+		return
+	}
+
+	key := responseCacheKey(r)
+	vary := varyHeaders(response.Header)
+
+	h.ResponseCache.Set(key, &CachedResponse{Header: http.Header{"Vary": vary}}, ttl, tags)
+	h.ResponseCache.Set(variantKey(key, r, vary), response, ttl, tags)
+}
@@ -0,0 +1,77 @@
+package wok
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manvalls/way"
+	"github.com/manvalls/wit"
+)
+
+func TestResponseCache(t *testing.T) {
+	runs := 0
+	h := single(func() Controller {
+		return NewTree(Cacheable(time.Minute, "items").Run(func(r Request) wit.Command {
+			runs++
+			return text("ITEMS")
+		}), nil)
+	})
+
+	cache := NewMemoryResponseCache()
+	h.ResponseCache = cache
+
+	for i := 0; i < 2; i++ {
+		w := serve(h, "GET", "/", "X-Wok-Instance-ID", "client")
+		expectStatus(t, w, 200)
+		if !strings.Contains(w.Body.String(), "ITEMS") {
+			t.Fatalf("unexpected response %s", w.Body.String())
+		}
+	}
+
+	if runs != 1 {
+		t.Errorf("expected a single run, got %d", runs)
+	}
+
+	serve(h, "GET", "/", "X-Wok-Instance-ID", "client", "Accept", "application/json")
+	if runs != 2 {
+		t.Errorf("responses of different formats were shared")
+	}
+
+	cache.Invalidate("items")
+	serve(h, "GET", "/", "X-Wok-Instance-ID", "client")
+	if runs != 3 {
+		t.Errorf("invalidated response was served")
+	}
+}
+
+func TestResponseCacheNeedsEveryPlan(t *testing.T) {
+	runs := 0
+	h := single(func() Controller {
+		return NewTree(List(
+			Cacheable(time.Minute).Run(func(r Request) wit.Command {
+				return text("CACHEABLE")
+			}),
+			Run(func(r Request) wit.Command {
+				runs++
+				return text("VOLATILE")
+			}),
+		), nil)
+	})
+
+	h.ResponseCache = NewMemoryResponseCache()
+	serve(h, "GET", "/", "X-Wok-Instance-ID", "client")
+	serve(h, "GET", "/", "X-Wok-Instance-ID", "client")
+
+	if runs != 2 {
+		t.Errorf("expected every request to run, got %d runs", runs)
+	}
+}
+
+func TestResponseCacheSkipsGuards(t *testing.T) {
+	h := redirectedTree()
+	h.Router = way.BuildRouter(way.RouteMap{"/a/new": {"a", "new"}})
+
+	expectStatus(t, serve(h, "GET", "/a/new", "X-Wok-Instance-ID", "client", "Authorization", "token"), 200)
+	expectStatus(t, serve(h, "GET", "/a/new", "X-Wok-Instance-ID", "client"), 401)
+}
@@ -0,0 +1,157 @@
+package wok
+
+// SetResult publishes the result of the current plan, making it available to
+// the plans which need it through Result
+func (r Request) SetResult(result interface{}) {
+	if r.current == nil {
+		return
+	}
+
+	r.redirectCond.L.Lock()
+	defer r.redirectCond.L.Unlock()
+
+	r.current.result = result
+}
+
+// Result retrieves the result published by the named plan, which must
+// have been declared as needed by the current plan
+func (r Request) Result(name string) interface{} {
+	provider := r.providers[name]
+	if provider == nil {
+		return nil
+	}
+
+	r.redirectCond.L.Lock()
+	defer r.redirectCond.L.Unlock()
+
+	return provider.result
+}
+
+// waiting checks whether any of the providers of this plan is still running
+func (info *planInfo) waiting() bool {
+	for _, provider := range info.providers {
+		if provider.started && !provider.finished {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findProvider looks for the nearest plan with the given name at
+// the same level or above the provided one
+func findProvider(info *planInfo, name string, candidates ...[]*planInfo) *planInfo {
+	var provider *planInfo
+
+	for _, list := range candidates {
+		for _, candidate := range list {
+			if candidate == info || candidate.name != name || candidate.offset > info.offset {
+				continue
+			}
+
+			if provider == nil || candidate.offset > provider.offset {
+				provider = candidate
+			}
+		}
+	}
+
+	return provider
+}
+
+// requireProviders moves the skipped plans needed by the plans about to run
+// to them, so their results are available even when their commands aren't
+// applied again, e.g. when navigating within the children of their controller
+func requireProviders(plansToRun []*planInfo, plansInfo []*planInfo, skipped []*planInfo) ([]*planInfo, []*planInfo) {
+	for i := 0; i < len(plansToRun); i++ {
+		info := plansToRun[i]
+
+		for _, name := range info.needs {
+			if findProvider(info, name, plansInfo, plansToRun) != nil {
+				continue
+			}
+
+			provider := findProvider(info, name, skipped)
+			if provider == nil {
+				continue
+			}
+
+			remaining := make([]*planInfo, 0, len(skipped))
+			for _, candidate := range skipped {
+				if candidate != provider {
+					remaining = append(remaining, candidate)
+				}
+			}
+
+			skipped = remaining
+			provider.required = true
+			plansToRun = append(plansToRun, provider)
+		}
+	}
+
+	return plansToRun, skipped
+}
+
+// schedulePlans resolves the providers of the plans about to run, sorting
+// them so that providers come before the plans which need them. Plans
+// involved in dependency cycles are flagged and moved to the end.
+func schedulePlans(plansToRun []*planInfo, plansInfo []*planInfo) []*planInfo {
+	pending := map[*planInfo]int{}
+	dependents := map[*planInfo][]*planInfo{}
+
+	for _, info := range plansToRun {
+		pending[info] = 0
+	}
+
+	for _, info := range plansToRun {
+		if len(info.needs) == 0 {
+			continue
+		}
+
+		info.providers = map[string]*planInfo{}
+		for _, name := range info.needs {
+			provider := findProvider(info, name, plansInfo, plansToRun)
+			if provider == nil {
+				continue
+			}
+
+			info.providers[name] = provider
+			if _, ok := pending[provider]; ok {
+				pending[info]++
+				dependents[provider] = append(dependents[provider], info)
+			}
+		}
+	}
+
+	// Plans are picked in their original order whenever possible,
+	// so that their commands keep being applied in that order
+	sorted := make([]*planInfo, 0, len(plansToRun))
+
+nextPlan:
+	for len(sorted) < len(plansToRun) {
+		for _, info := range plansToRun {
+			if pending[info] != 0 {
+				continue
+			}
+
+			pending[info] = -1
+			sorted = append(sorted, info)
+
+			for _, dependent := range dependents[info] {
+				pending[dependent]--
+			}
+
+			continue nextPlan
+		}
+
+		for _, info := range plansToRun {
+			if pending[info] > 0 {
+				info.cyclic = true
+				sorted = append(sorted, info)
+			}
+		}
+
+		break
+	}
+
+	return sorted
+}
@@ -0,0 +1,105 @@
+package wok
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manvalls/way"
+	"github.com/manvalls/wit"
+)
+
+func userTree() Handler {
+	child := func(name string) Controller {
+		return NewTree(Needs("user").Run(func(r Request) wit.Command {
+			return text(fmt.Sprint(name, ":", r.Result("user")))
+		}), nil)
+	}
+
+	return Handler{
+		Root: func() Controller {
+			return NewTree(As("user").Run(func(r Request) wit.Command {
+				time.Sleep(5 * time.Millisecond)
+				r.SetResult("alice")
+				return text("ROOT")
+			}), map[string]Controller{
+				"a": child("a"),
+				"b": child("b"),
+			})
+		},
+		Router: way.BuildRouter(way.RouteMap{"/a": {"a"}, "/b": {"b"}}),
+	}
+}
+
+func TestNeedsFullLoad(t *testing.T) {
+	body := serve(userTree(), "GET", "/b", "Accept", "application/json").Body.String()
+	if !strings.Contains(body, "ROOT") || !strings.Contains(body, "b:alice") {
+		t.Errorf("unexpected response %s", body)
+	}
+}
+
+func TestNeedsPartialNavigation(t *testing.T) {
+	body := serve(userTree(), "GET", "/b",
+		"Accept", "application/json",
+		"X-Requested-With", "XMLHttpRequest",
+		"X-Navigation", "true",
+		"X-Wok-Route", ",a",
+	).Body.String()
+
+	if !strings.Contains(body, "b:alice") {
+		t.Errorf("provider didn't run for its dependent: %s", body)
+	}
+
+	if strings.Contains(body, "ROOT") {
+		t.Errorf("commands of the provider were applied again: %s", body)
+	}
+}
+
+func TestNeedsOrdersPlans(t *testing.T) {
+	h := single(func() Controller {
+		return NewTree(List(
+			Needs("second").Run(func(r Request) wit.Command {
+				return text(fmt.Sprint("third:", r.Result("second")))
+			}),
+			As("second").Needs("first").Run(func(r Request) wit.Command {
+				r.SetResult(fmt.Sprint(r.Result("first"), "+2"))
+				return nil
+			}),
+			As("first").Run(func(r Request) wit.Command {
+				time.Sleep(5 * time.Millisecond)
+				r.SetResult("1")
+				return nil
+			}),
+		), nil)
+	})
+
+	body := serve(h, "GET", "/", "Accept", "application/json").Body.String()
+	if !strings.Contains(body, "third:1+2") {
+		t.Errorf("unexpected response %s", body)
+	}
+}
+
+func TestNeedsCycles(t *testing.T) {
+	h := single(func() Controller {
+		return NewTree(List(
+			As("a").Needs("b").Run(func(r Request) wit.Command {
+				return text("CYCLE_A")
+			}),
+			As("b").Needs("a").Run(func(r Request) wit.Command {
+				return text("CYCLE_B")
+			}),
+			Run(func(r Request) wit.Command {
+				return text("ACYCLIC")
+			}),
+		), nil)
+	})
+
+	w := serve(h, "GET", "/", "Accept", "application/json")
+	expectStatus(t, w, 500)
+
+	body := w.Body.String()
+	if strings.Contains(body, "CYCLE_") || !strings.Contains(body, "ACYCLIC") {
+		t.Errorf("unexpected response %s", body)
+	}
+}
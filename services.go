@@ -0,0 +1,98 @@
+package wok
+
+import (
+	"reflect"
+)
+
+// Services holds injected values indexed by type
+type Services map[reflect.Type]interface{}
+
+// Provide returns a copy of these services including the given values,
+// indexed by their dynamic type
+func (s Services) Provide(values ...interface{}) Services {
+	result := make(Services, len(s)+len(values))
+	for typ, value := range s {
+		result[typ] = value
+	}
+
+	for _, value := range values {
+		if value != nil {
+			result[reflect.TypeOf(value)] = value
+		}
+	}
+
+	return result
+}
+
+// ProvideAs returns a copy of these services including the given value,
+// indexed by the type pointed to by ptr, e.g. ProvideAs((*Logger)(nil), logger)
+func (s Services) ProvideAs(ptr interface{}, value interface{}) Services {
+	typ := reflect.TypeOf(ptr)
+	if typ == nil || typ.Kind() != reflect.Ptr {
+		panic("wok: ProvideAs requires a pointer")
+	}
+
+	if value != nil && !reflect.TypeOf(value).AssignableTo(typ.Elem()) {
+		panic("wok: cannot provide " + reflect.TypeOf(value).String() + " as " + typ.Elem().String())
+	}
+
+	result := make(Services, len(s)+1)
+	for typ, value := range s {
+		result[typ] = value
+	}
+
+	result[typ.Elem()] = value
+	return result
+}
+
+// serviceScope chains the services provided at each level of the route
+type serviceScope struct {
+	services Services
+	parent   *serviceScope
+}
+
+func (s *serviceScope) extend(plan Plan) *serviceScope {
+	if plan == nil {
+		return s
+	}
+
+	for _, p := range plan.Procedure().plans {
+		if len(p.services) > 0 {
+			s = &serviceScope{p.services, s}
+		}
+	}
+
+	return s
+}
+
+func (s *serviceScope) lookup(typ reflect.Type) (interface{}, bool) {
+	for scope := s; scope != nil; scope = scope.parent {
+		if value, ok := scope.services[typ]; ok {
+			return value, true
+		}
+	}
+
+	return nil, false
+}
+
+// Service sets the value pointed to by ptr to the nearest service of its
+// type, returning false if there's none
+func (r ReadOnlyRequest) Service(ptr interface{}) bool {
+	target := reflect.ValueOf(ptr)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		panic("wok: Service requires a non-nil pointer")
+	}
+
+	value, ok := r.services.lookup(target.Type().Elem())
+	if !ok {
+		return false
+	}
+
+	if value == nil {
+		target.Elem().Set(reflect.Zero(target.Type().Elem()))
+	} else {
+		target.Elem().Set(reflect.ValueOf(value))
+	}
+
+	return true
+}
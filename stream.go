@@ -0,0 +1,89 @@
+package wok
+
+import (
+	"io"
+	"net/http"
+	"sync"
+
+	"github.com/manvalls/wit"
+)
+
+// patchStream delivers the commands of streamed plans once the main
+// response has been sent
+type patchStream struct {
+	mutex   sync.Mutex
+	started bool
+	closed  bool
+	pending []wit.Command
+	send    func(wit.Command)
+}
+
+func newPatchStream(send func(wit.Command)) *patchStream {
+	return &patchStream{send: send}
+}
+
+func newWriterPatchStream(w io.Writer, format Format) *patchStream {
+	return newPatchStream(func(command wit.Command) {
+		format.NewPatchRenderer(command).Render(w)
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	})
+}
+
+func (s *patchStream) push(command wit.Command) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed || wit.IsNil(command) {
+		return
+	}
+
+	if !s.started {
+		s.pending = append(s.pending, command)
+		return
+	}
+
+	s.send(command)
+}
+
+// start sends pending commands and lets the following ones through
+func (s *patchStream) start() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.closed {
+		return
+	}
+
+	s.started = true
+	for _, command := range s.pending {
+		s.send(command)
+	}
+
+	s.pending = nil
+}
+
+// close discards current and future commands
+func (s *patchStream) close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.closed = true
+	s.pending = nil
+}
+
+// detached checks whether the commands of some plan
+// will be delivered after the response
+func (v *validators) detached() bool {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	for _, info := range v.plans {
+		if info.detached {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,89 @@
+package wok
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manvalls/wit"
+)
+
+func TestStreamHTMLPatches(t *testing.T) {
+	h := single(func() Controller {
+		return NewTree(List(
+			Command(text("FAST")),
+			Stream().Run(func(r Request) wit.Command {
+				time.Sleep(20 * time.Millisecond)
+				return text("<p>SLOW</p>")
+			}),
+		), nil)
+	})
+
+	w := serve(h, "GET", "/")
+	expectStatus(t, w, 200)
+	body := w.Body.String()
+
+	fast := strings.Index(body, "FAST")
+	client := strings.Index(body, "w.SPAPatch=")
+	patch := strings.Index(body, `SPAPatch([3,"body",[14,"\u003cp>SLOW\u003c/p>"]])`)
+
+	if fast < 0 || client < 0 || patch < 0 {
+		t.Fatalf("missing fast commands, client or patch: %s", body)
+	}
+
+	if client > fast || fast > patch {
+		t.Errorf("unexpected order: %s", body)
+	}
+
+	if !strings.HasSuffix(body, "</script></body></html>") {
+		t.Errorf("patch isn't inserted before the end of the document: %s", body)
+	}
+}
+
+func TestStreamWithoutDetachedPlans(t *testing.T) {
+	h := single(func() Controller {
+		return NewTree(Run(func(r Request) wit.Command {
+			return text("QUICK")
+		}), nil)
+	})
+
+	body := serve(h, "GET", "/").Body.String()
+	if !strings.Contains(body, "QUICK") || strings.Contains(body, "SPAPatch") {
+		t.Errorf("unexpected response: %s", body)
+	}
+}
+
+func TestStreamWithoutPatchRenderer(t *testing.T) {
+	h := single(func() Controller {
+		return NewTree(Stream().Run(func(r Request) wit.Command {
+			time.Sleep(20 * time.Millisecond)
+			return text("SLOW")
+		}), nil)
+	})
+
+	body := serve(h, "GET", "/", "Accept", "application/json").Body.String()
+	if !strings.Contains(body, "SLOW") {
+		t.Errorf("streamed plan wasn't waited for: %s", body)
+	}
+}
+
+func TestSuspense(t *testing.T) {
+	h := single(func() Controller {
+		return NewTree(Suspense(5*time.Millisecond, text("LOADING")).Run(func(r Request) wit.Command {
+			time.Sleep(30 * time.Millisecond)
+			return text("REAL")
+		}), nil)
+	})
+
+	body := serve(h, "GET", "/").Body.String()
+	placeholder := strings.Index(body, "LOADING")
+	patch := strings.Index(body, `SPAPatch([3,"body",[14,"REAL"]])`)
+	if placeholder < 0 || patch < placeholder {
+		t.Errorf("expected the placeholder followed by a patch: %s", body)
+	}
+
+	body = serve(h, "GET", "/", "Accept", "application/json").Body.String()
+	if strings.Contains(body, "LOADING") || !strings.Contains(body, "REAL") {
+		t.Errorf("expected the plan to be waited for: %s", body)
+	}
+}
@@ -0,0 +1,44 @@
+package wok
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/manvalls/wit"
+)
+
+func TestTimeoutAppliesFallback(t *testing.T) {
+	cancelled := make(chan struct{})
+	h := single(func() Controller {
+		return NewTree(Timeout(10*time.Millisecond, text("FALLBACK")).Run(func(r Request) wit.Command {
+			<-r.Done()
+			close(cancelled)
+			return text("SLOW")
+		}), nil)
+	})
+
+	body := serve(h, "GET", "/").Body.String()
+	if !strings.Contains(body, "FALLBACK") || strings.Contains(body, "SLOW") {
+		t.Errorf("unexpected response %s", body)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("the context of the plan wasn't cancelled")
+	}
+}
+
+func TestTimeoutKeepsFastPlans(t *testing.T) {
+	h := single(func() Controller {
+		return NewTree(Timeout(time.Second, text("FALLBACK")).Run(func(r Request) wit.Command {
+			return text("FAST")
+		}), nil)
+	})
+
+	body := serve(h, "GET", "/").Body.String()
+	if !strings.Contains(body, "FAST") || strings.Contains(body, "FALLBACK") {
+		t.Errorf("unexpected response %s", body)
+	}
+}
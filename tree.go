@@ -0,0 +1,98 @@
+package wok
+
+import (
+	"sort"
+)
+
+// Tree implements a controller with a fixed set of named children
+type Tree struct {
+	plan     Plan
+	children map[string]Controller
+	param    func(id string) Controller
+	notFound Controller
+}
+
+// NewTree builds a controller applying the given plan, whose
+// children are resolved using the provided map
+func NewTree(plan Plan, children map[string]Controller) Tree {
+	t := Tree{
+		plan:     plan,
+		children: make(map[string]Controller, len(children)),
+	}
+
+	for id, child := range children {
+		t.children[id] = child
+	}
+
+	return t
+}
+
+// Child adds a named child to the tree
+func (t Tree) Child(id string, child Controller) Tree {
+	children := make(map[string]Controller, len(t.children)+1)
+	for id, child := range t.children {
+		children[id] = child
+	}
+
+	children[id] = child
+	t.children = children
+	return t
+}
+
+// Wildcard resolves the provided controller for every id not matching a child
+func (t Tree) Wildcard(child Controller) Tree {
+	return t.Param(func(string) Controller {
+		return child
+	})
+}
+
+// Param resolves the controller returned by the provided function for
+// every id not matching a child
+func (t Tree) Param(fn func(id string) Controller) Tree {
+	t.param = fn
+	return t
+}
+
+// NotFound resolves the provided controller for every id not matching
+// a child, when there's no wildcard, instead of the NotFound controller
+func (t Tree) NotFound(child Controller) Tree {
+	t.notFound = child
+	return t
+}
+
+// Plan returns the plan for this controller
+func (t Tree) Plan() Plan {
+	if t.plan == nil {
+		return Nil
+	}
+
+	return t.plan
+}
+
+// Resolve returns the child with the given id
+func (t Tree) Resolve(id string) Controller {
+	if child, ok := t.children[id]; ok {
+		return child
+	}
+
+	if t.param != nil {
+		return t.param(id)
+	}
+
+	if t.notFound != nil {
+		return t.notFound
+	}
+
+	return NotFound
+}
+
+// Children returns the ids of the named children of this controller
+func (t Tree) Children() []string {
+	ids := make([]string, 0, len(t.children))
+	for id := range t.children {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+	return ids
+}
@@ -0,0 +1,33 @@
+package wok
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/manvalls/way"
+	"github.com/manvalls/wit"
+)
+
+func TestNotFoundSegments(t *testing.T) {
+	h := Handler{
+		Root: func() Controller {
+			return NewTree(Run(func(r Request) wit.Command {
+				return text("ROOT")
+			}), map[string]Controller{
+				"a": NewTree(Nil, nil),
+			})
+		},
+		Router: way.BuildRouter(way.RouteMap{"/a/missing": {"a", "missing"}}),
+		NotFound: Run(func(r Request) wit.Command {
+			return text("MISSING")
+		}),
+	}
+
+	w := serve(h, "GET", "/a/missing")
+	expectStatus(t, w, 404)
+
+	body := w.Body.String()
+	if !strings.Contains(body, "ROOT") || !strings.Contains(body, "MISSING") {
+		t.Errorf("unexpected response %s", body)
+	}
+}
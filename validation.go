@@ -0,0 +1,202 @@
+package wok
+
+import (
+	"html"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/manvalls/wit"
+)
+
+// InvalidClass is added to fields which failed validation
+const InvalidClass = "w-invalid"
+
+// ValidationErrors maps field names to the reasons they failed validation
+type ValidationErrors map[string][]string
+
+// Add appends a failure message to the given field
+func (e ValidationErrors) Add(field string, message string) {
+	e[field] = append(e[field], message)
+}
+
+func (e ValidationErrors) fields() []string {
+	fields := make([]string, 0, len(e))
+	for field := range e {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+	return fields
+}
+
+func (e ValidationErrors) Error() string {
+	messages := []string{}
+	for _, field := range e.fields() {
+		messages = append(messages, field+": "+strings.Join(e[field], ", "))
+	}
+
+	return strings.Join(messages, "; ")
+}
+
+// Err returns these errors as an error, or nil if there are none
+func (e ValidationErrors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// ValidationErrorsOf extracts the validation errors described by the given
+// error, as returned by BindForm, or nil if there are none
+func ValidationErrorsOf(err error) ValidationErrors {
+	switch errs := err.(type) {
+	case ValidationErrors:
+		return errs
+	case ParamErrors:
+		result := ValidationErrors{}
+		for field, message := range errs {
+			result.Add(field, message)
+		}
+
+		return result
+	}
+
+	return nil
+}
+
+func cssString(value string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\a `).Replace(value) + `"`
+}
+
+// Command builds the commands showing these errors next to the fields they
+// refer to, matched by name, within the elements matching the optional scope,
+// e.g. a form. Previous errors are removed first, so an empty set of errors
+// clears them.
+func (e ValidationErrors) Command(scope ...string) wit.Command {
+	if len(scope) == 0 {
+		return e.command("")
+	}
+
+	commands := make([]wit.Command, len(scope))
+	for i, s := range scope {
+		commands[i] = e.command(s + " ")
+	}
+
+	return wit.List(commands...)
+}
+
+func (e ValidationErrors) command(prefix string) wit.Command {
+	commands := []wit.Command{
+		wit.S(prefix + "[data-w-error]").All(wit.Remove),
+		wit.S(prefix+"."+InvalidClass).All(wit.RmClass(InvalidClass), wit.RmAttr("aria-invalid")),
+	}
+
+	for _, field := range e.fields() {
+		if len(e[field]) == 0 {
+			continue
+		}
+
+		messages := ""
+		for _, message := range e[field] {
+			messages += `<span data-w-error="` + html.EscapeString(field) + `">` + html.EscapeString(message) + "</span>"
+		}
+
+		selector := wit.S(prefix + "[name=" + cssString(field) + "]")
+		commands = append(
+			commands,
+			selector.All(wit.AddClass(InvalidClass), wit.AddAttr(map[string]string{"aria-invalid": "true"})),
+			selector.One(wit.InsertAfter(wit.FromString(messages))),
+		)
+	}
+
+	return wit.List(commands...)
+}
+
+// Rule checks the values of a field, returning a failure message
+// or an empty string if they're valid
+type Rule func(values []string) string
+
+// Validator maps field names to the rules they must follow
+type Validator map[string][]Rule
+
+// Validate checks the provided values, which may come from PostValues, the
+// current call or a socket event, returning nil if they're valid
+func (v Validator) Validate(values url.Values) ValidationErrors {
+	var errs ValidationErrors
+
+	fields := make([]string, 0, len(v))
+	for field := range v {
+		fields = append(fields, field)
+	}
+
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		for _, rule := range v[field] {
+			if message := rule(values[field]); message != "" {
+				if errs == nil {
+					errs = ValidationErrors{}
+				}
+
+				errs.Add(field, message)
+				break
+			}
+		}
+	}
+
+	return errs
+}
+
+// Required fails when the field is missing or empty
+func Required(message string) Rule {
+	return func(values []string) string {
+		if len(values) == 0 || strings.TrimSpace(values[0]) == "" {
+			return message
+		}
+
+		return ""
+	}
+}
+
+// MinLength fails when a value has less than n characters
+func MinLength(n int, message string) Rule {
+	return func(values []string) string {
+		for _, value := range values {
+			if utf8.RuneCountInString(value) < n {
+				return message
+			}
+		}
+
+		return ""
+	}
+}
+
+// MaxLength fails when a value has more than n characters
+func MaxLength(n int, message string) Rule {
+	return func(values []string) string {
+		for _, value := range values {
+			if utf8.RuneCountInString(value) > n {
+				return message
+			}
+		}
+
+		return ""
+	}
+}
+
+// Matches fails when a value doesn't match the given expression
+func Matches(expression *regexp.Regexp, message string) Rule {
+	return func(values []string) string {
+		for _, value := range values {
+			if !expression.MatchString(value) {
+				return message
+			}
+		}
+
+		return ""
+	}
+}
@@ -0,0 +1,41 @@
+package wok
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/manvalls/way"
+	"github.com/manvalls/wit"
+)
+
+// serve sends a request with the given header pairs to the handler
+func serve(h Handler, method string, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// text builds a command appending the given HTML to the body
+func text(html string) wit.Command {
+	return wit.Body.One(wit.Append(wit.FromString(html)))
+}
+
+// single builds a handler serving the given root at /
+func single(root func() Controller) Handler {
+	return Handler{
+		Root:   root,
+		Router: way.BuildRouter(way.RouteMap{"/": {}}),
+	}
+}
+
+func expectStatus(t *testing.T, w *httptest.ResponseRecorder, statusCode int) {
+	t.Helper()
+	if w.Code != statusCode {
+		t.Fatalf("expected status %d, got %d: %s", statusCode, w.Code, w.Body.String())
+	}
+}
@@ -14,7 +14,7 @@ import (
 	"github.com/manvalls/wit"
 )
 
-func (h Handler) handleWS(ctx context.Context, conn *websocket.Conn) {
+func (h Handler) handleWS(ctx context.Context, conn *websocket.Conn, remoteAddr string) {
 	defer conn.Close()
 
 	rootCtx, rootCancel := context.WithCancel(ctx)
@@ -24,6 +24,7 @@ func (h Handler) handleWS(ctx context.Context, conn *websocket.Conn) {
 	mapsLock := sync.Mutex{}
 	cancels := make(map[string]context.CancelFunc)
 	inChannels := make(map[string]chan url.Values)
+	limitKeys := make(map[string]string)
 
 	cleanup := func(id string) {
 		mapsLock.Lock()
@@ -45,6 +46,8 @@ func (h Handler) handleWS(ctx context.Context, conn *websocket.Conn) {
 			close(chIn)
 			delete(inChannels, id)
 		}
+
+		delete(limitKeys, id)
 	}
 
 	for {
@@ -87,10 +90,14 @@ func (h Handler) handleWS(ctx context.Context, conn *websocket.Conn) {
 			chIn := make(chan url.Values, h.InputBuffer)
 
 			req = req.WithContext(ctx)
+			req.RemoteAddr = remoteAddr
 
 			mapsLock.Lock()
 			cancels[id] = cancel
 			inChannels[id] = chIn
+			if h.EventLimiter != nil {
+				limitKeys[id] = h.eventLimitKey(req)
+			}
 			mapsLock.Unlock()
 
 			go func() {
@@ -157,6 +164,17 @@ func (h Handler) handleWS(ctx context.Context, conn *websocket.Conn) {
 				defer mapsLock.Unlock()
 
 				chIn, ok := inChannels[id]
+				if ok && h.EventLimiter != nil {
+					allowed, retryAfter := h.EventLimiter.Allow(limitKeys[id])
+					if !allowed {
+						mutex.Lock()
+						defer mutex.Unlock()
+
+						conn.WriteMessage(websocket.TextMessage, append([]byte("ERROR "+id+"\r\n"), rateLimitError(retryAfter)...))
+						return
+					}
+				}
+
 				if ok {
 					params, _ := url.ParseQuery(string(data))
 					select {